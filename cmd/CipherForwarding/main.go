@@ -22,8 +22,14 @@
 package main
 
 import (
+    "context"
+    "fmt"
+    "os"
+    "os/signal"
+
     "github.com/NEXXTLAB/go-smarty-reader/cmd/util"
     "github.com/NEXXTLAB/go-smarty-reader/smarty"
+    "github.com/golang/glog"
 )
 
 func main() {
@@ -31,23 +37,43 @@ func main() {
     // Function defined in cmd/util/CommonFlagParsing.go
     flags := util.StartupFlagParsing()
 
+    // Function defined in cmd/util/CommonSerialSetup.go
+    serialConfig, err := util.SerialSetup(*flags.Device, flags.Serial)
+    if err != nil {
+        glog.Exitln(err)
+    }
+
     // Create a new smarty reader which will not decrypt the telegrams,
     // but return the initial value and the cipher text
     // The serial connection is established right away
     // smartyObj is the object you may invoke methods on
-    smartyObj := smarty.NewCipherForwarder(*flags.Device)
-
-    // Print 100 initial value / cipher tuples
-    for counter := 0; counter < 100; counter++ {
-        // Wait and get the next telegram,
-        // return the initial value and cipher text
-        iv, cipher, gcmTag := smartyObj.GetTelegram()
-        // Print as console output
-        println(string(iv))
-        println(string(cipher))
-        println(string(gcmTag))
-        println("~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~")
+    smartyObj, err := smarty.NewCipherForwarderWithConfig(serialConfig)
+    if err != nil {
+        glog.Exitln(err)
     }
     // After use, remember to close to serial port!
-    smartyObj.Disconnect()
+    defer smartyObj.Disconnect()
+    smartyObj.SetTagLength(*flags.Security.TagLength)
+
+    // Stop reading on Ctrl+C
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    interrupt := make(chan os.Signal, 1)
+    signal.Notify(interrupt, os.Interrupt)
+    go func() {
+        <-interrupt
+        cancel()
+    }()
+
+    // Print every initial value / cipher tuple as console output, one line per telegram
+    // Redirect the output to a file to decrypt it at a later date using the OfflineDecryption example
+    // The handler is called from a background goroutine for every telegram
+    err = smartyObj.Subscribe(ctx, smarty.DefaultStreamOptions(), func(telegram smarty.Telegram) {
+        // The system title identifies the meter, eg. "SAG-114004"
+        glog.Infof("Telegram of %s\n", telegram.SystemTitle)
+        fmt.Println(telegram.Tuple())
+    })
+    if err != context.Canceled {
+        glog.Errorln(err)
+    }
 }
@@ -23,6 +23,7 @@ package main
 
 import (
 	"github.com/NEXXTLAB/go-smarty-reader/cmd/util"
+	"github.com/golang/glog"
 )
 
 func main() {
@@ -32,7 +33,10 @@ func main() {
 
 	// MQTT Setup extracted in a separate function.
 	// Functions defined in cmd/util/CommonMqttSetup.go
-	client := util.MqttSetup(util.GetHostname(), flags.Mqtt)
+	client, err := util.MqttSetup(util.GetHostname(), flags.Mqtt)
+	if err != nil {
+		glog.Exitln(err)
+	}
 
 	// Publish "Hello" to the ""nexxtlab/dev/smarty/go/<hostname>/World" topic, without unit.
 	client.Publish("World", "Hello", "", false, false)
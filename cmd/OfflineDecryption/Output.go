@@ -0,0 +1,151 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   The output formats of the decrypted telegrams: the plain text as sent by the meter, one JSON object per telegram
+   and line, or CSV with one row per OBIS object.
+*/
+
+package main
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"strconv"
+	"time"
+
+	"github.com/NEXXTLAB/go-smarty-reader/smarty/obis"
+	"github.com/golang/glog"
+)
+
+// A decrypted telegram with the details of its origin
+type record struct {
+	// The file name and position of the telegram, eg. "archive.txt:12"
+	Source       string
+	SystemTitle  string
+	FrameCounter uint32
+	PlainText    []byte
+	// The parsed telegram, only valid if Parsed is true
+	Reading obis.Reading
+	Parsed  bool
+}
+
+type output interface {
+	write(r record) error
+	flush() error
+}
+
+func newOutput(format string, w io.Writer) (output, error) {
+	switch format {
+	case "text":
+		return &textOutput{w: w}, nil
+	case "json":
+		return &jsonOutput{encoder: json.NewEncoder(w)}, nil
+	case "csv":
+		// The header is written up front, an archive without any parsed telegram still yields the columns
+		writer := csv.NewWriter(w)
+		if err := writer.Write(csvHeader); err != nil {
+			return nil, err
+		}
+		return &csvOutput{writer: writer}, nil
+	}
+	return nil, fmt.Errorf("unknown output format %q, expected text, json or csv", format)
+}
+
+type textOutput struct {
+	w io.Writer
+}
+
+func (to *textOutput) write(r record) error {
+	_, err := fmt.Fprintf(to.w, "%s\n~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~\n", r.PlainText)
+	return err
+}
+
+func (to *textOutput) flush() error {
+	return nil
+}
+
+type jsonObject struct {
+	ID    string `json:"obis"`
+	Value string `json:"value"`
+	Unit  string `json:"unit,omitempty"`
+}
+
+type jsonTelegram struct {
+	Source       string       `json:"source"`
+	SystemTitle  string       `json:"systemTitle"`
+	FrameCounter uint32       `json:"frameCounter"`
+	Timestamp    *time.Time   `json:"timestamp,omitempty"`
+	EquipmentID  string       `json:"equipmentId,omitempty"`
+	Objects      []jsonObject `json:"objects,omitempty"`
+	PlainText    string       `json:"plainText"`
+}
+
+type jsonOutput struct {
+	encoder *json.Encoder
+}
+
+func (jo *jsonOutput) write(r record) error {
+	telegram := jsonTelegram{
+		Source:       r.Source,
+		SystemTitle:  r.SystemTitle,
+		FrameCounter: r.FrameCounter,
+		PlainText:    string(r.PlainText),
+	}
+	if r.Parsed {
+		telegram.Timestamp = &r.Reading.Timestamp
+		telegram.EquipmentID = r.Reading.EquipmentID
+		for _, object := range r.Reading.Objects {
+			telegram.Objects = append(telegram.Objects, jsonObject{ID: object.ID, Value: object.RawValue,
+				Unit: object.Unit})
+		}
+	}
+	return jo.encoder.Encode(telegram)
+}
+
+func (jo *jsonOutput) flush() error {
+	return nil
+}
+
+var csvHeader = []string{"source", "system_title", "frame_counter", "timestamp", "obis", "value", "unit"}
+
+type csvOutput struct {
+	writer *csv.Writer
+}
+
+func (co *csvOutput) write(r record) error {
+	// Only parsed telegrams can be split into rows
+	if !r.Parsed {
+		glog.Warningf("%s: telegram could not be parsed, skipped in CSV output\n", r.Source)
+		return nil
+	}
+	for _, object := range r.Reading.Objects {
+		err := co.writer.Write([]string{r.Source, r.SystemTitle, strconv.FormatUint(uint64(r.FrameCounter), 10),
+			r.Reading.Timestamp.Format(time.RFC3339), object.ID, object.RawValue, object.Unit})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (co *csvOutput) flush() error {
+	co.writer.Flush()
+	return co.writer.Error()
+}
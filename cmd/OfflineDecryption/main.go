@@ -0,0 +1,238 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   Example on how to decrypt archived telegrams at a later date. The archives are either raw captures of the P1
+   port, as written by the P1Capture example, or the tuples written by the CipherForwarding example, eg.
+       go run ./cmd/CipherForwarding -device /dev/ttyUSB0 > archive.txt
+       go run ./cmd/OfflineDecryption -key yourKey -format json archive.txt
+   Without file arguments the archive is read from stdin. The totals of decrypted telegrams, authentication failures
+   and framing errors are reported once all archives have been read.
+*/
+
+package main
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/NEXXTLAB/go-smarty-reader/cmd/util"
+	"github.com/NEXXTLAB/go-smarty-reader/smarty"
+	"github.com/NEXXTLAB/go-smarty-reader/smarty/obis"
+	"github.com/golang/glog"
+)
+
+// Longest tuple line accepted, a telegram of 64 kB written as hex
+const maxTupleLength = 256 * 1024
+
+// Counters reported after all archives have been read
+type totals struct {
+	decrypted, authFailures, framingErrors int
+}
+
+// Struct decrypting the archives into the output
+type archiveDecryptor struct {
+	decryptor smarty.Decryptor
+	tagLength int
+	output    output
+	totals
+}
+
+func main() {
+
+	// Offline specific flags, parsed together with the common flags
+	inputFormat := flag.String("input", "auto",
+		"Archive format: raw (P1 port bytes or P1Capture file), tuple (CipherForwarding output) or auto to detect "+
+			"it per archive.")
+	outputFormat := flag.String("format", "text", "Output format: text, json or csv.")
+
+	// Function defined in cmd/util/CommonFlagParsing.go
+	// The device flag is not used, the archives are passed as arguments
+	flags := util.StartupFlagParsing()
+
+	// Function defined in cmd/util/CommonSecuritySetup.go
+	securityConfig, err := util.SecuritySetup(flags.Security)
+	if err != nil {
+		glog.Exitln(err)
+	}
+	decryptor, err := smarty.NewDecryptorWithConfig(*flags.Key, securityConfig)
+	if err != nil {
+		glog.Exitln(err)
+	}
+	output, err := newOutput(*outputFormat, os.Stdout)
+	if err != nil {
+		glog.Exitln(err)
+	}
+	ad := &archiveDecryptor{
+		decryptor: decryptor,
+		tagLength: *flags.Security.TagLength,
+		output:    output,
+	}
+
+	archives := flag.Args()
+	if len(archives) == 0 {
+		archives = []string{"-"}
+	}
+	for _, archive := range archives {
+		if err = ad.decryptArchive(archive, *inputFormat); err != nil {
+			glog.Errorln(err)
+		}
+	}
+	if err = output.flush(); err != nil {
+		glog.Errorln(err)
+	}
+
+	fmt.Fprintf(os.Stderr, "%d telegrams decrypted, %d authentication failures, %d framing errors\n",
+		ad.decrypted, ad.authFailures, ad.framingErrors)
+}
+
+func (ad *archiveDecryptor) decryptArchive(name, format string) error {
+	input := io.Reader(os.Stdin)
+	if name == "-" {
+		name = "stdin"
+	} else {
+		file, err := os.Open(name)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	reader := bufio.NewReader(input)
+	if format == "auto" {
+		format = detectFormat(reader)
+	}
+	switch format {
+	case "raw":
+		// Files written by the P1Capture example hold the byte stream in timestamped chunks
+		if smarty.IsCapture(reader) {
+			replay, err := smarty.NewReplayReader(reader, 0)
+			if err != nil {
+				return fmt.Errorf("%s: %v", name, err)
+			}
+			return ad.decryptCapture(name, replay)
+		}
+		return ad.decryptCapture(name, reader)
+	case "tuple":
+		return ad.decryptTuples(name, reader)
+	}
+	return fmt.Errorf("unknown archive format %q, expected raw, tuple or auto", format)
+}
+
+// Tuple archives only contain text, raw captures contain binary data right from the first telegram
+func detectFormat(reader *bufio.Reader) string {
+	if smarty.IsCapture(reader) {
+		return "raw"
+	}
+	start, _ := reader.Peek(512)
+	for _, b := range start {
+		if (b < ' ' || b > '~') && b != '\r' && b != '\n' && b != '\t' {
+			return "raw"
+		}
+	}
+	return "tuple"
+}
+
+func (ad *archiveDecryptor) decryptCapture(name string, input io.Reader) error {
+	reader := smarty.NewTelegramReader(input)
+	reader.SetTagLength(ad.tagLength)
+	for number := 1; ; number++ {
+		frame, err := reader.ReadTelegram()
+		switch {
+		case err == io.EOF:
+			return nil
+		case err == io.ErrUnexpectedEOF || errors.Is(err, smarty.ErrFraming):
+			ad.count(fmt.Sprintf("%s:telegram %d", name, number), err)
+			if err == io.ErrUnexpectedEOF {
+				return nil
+			}
+			continue
+		case err != nil:
+			return fmt.Errorf("%s: %v", name, err)
+		}
+
+		plainText, err := ad.decryptor.DecryptFrame(frame)
+		if err = ad.write(fmt.Sprintf("%s:telegram %d", name, number), frame, plainText, err); err != nil {
+			return err
+		}
+	}
+}
+
+func (ad *archiveDecryptor) decryptTuples(name string, input io.Reader) error {
+	scanner := bufio.NewScanner(input)
+	scanner.Buffer(make([]byte, 0, 4096), maxTupleLength)
+	for number := 1; scanner.Scan(); number++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		source := fmt.Sprintf("%s:%d", name, number)
+		frame, err := smarty.ParseTuple(line)
+		if err != nil {
+			ad.count(source, err)
+			continue
+		}
+		// Tuples carry no security control byte, the configured one applies
+		plainText, err := ad.decryptor.Decrypt(frame.InitialValue(), frame.CipherText())
+		if err = ad.write(source, frame, plainText, err); err != nil {
+			return err
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("%s: %v", name, err)
+	}
+	return nil
+}
+
+// Writes a decrypted telegram, or counts the decryption error
+// Return:
+// * err: error if the output could not be written
+func (ad *archiveDecryptor) write(source string, frame smarty.Frame, plainText []byte, decryptErr error) error {
+	if decryptErr != nil {
+		ad.count(source, decryptErr)
+		return nil
+	}
+	ad.decrypted++
+
+	r := record{
+		Source:       source,
+		SystemTitle:  frame.SystemTitle.String(),
+		FrameCounter: binary.BigEndian.Uint32(frame.FrameCounter),
+		PlainText:    plainText,
+	}
+	reading, err := obis.ParseWithMode(plainText, obis.ChecksumLenient)
+	if err == nil {
+		r.Reading, r.Parsed = reading, true
+	}
+	return ad.output.write(r)
+}
+
+func (ad *archiveDecryptor) count(source string, err error) {
+	if errors.Is(err, smarty.ErrAuthFailed) {
+		ad.authFailures++
+	} else {
+		ad.framingErrors++
+	}
+	glog.Warningf("%s: %s\n", source, err)
+}
@@ -0,0 +1,182 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"encoding/csv"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+const testKey = "000102030405060708090A0B0C0D0E0F"
+
+var testPlainText = []byte("/SAG5\r\n\r\n0-0:1.0.0(180130102122W)\r\n1-0:1.8.0(000123.456*kWh)\r\n!0000\r\n")
+
+// Writes the same 3 telegrams as raw P1 bytes, as P1Capture file with every telegram split across 2 chunks and
+// as CipherForwarding tuples
+func writeArchives(t *testing.T, directory string) (archives map[string]string) {
+	encoder, err := smarty.NewEncoder(testKey, []byte("SAGgp\x00\x00\x01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var raw, capture, tuples bytes.Buffer
+	writer, err := smarty.NewCaptureWriter(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	start := time.Now()
+	for counter := uint32(1); counter <= 3; counter++ {
+		frame, err := encoder.EncryptFrame(testPlainText, counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		telegram, err := frame.Bytes()
+		if err != nil {
+			t.Fatal(err)
+		}
+		raw.Write(telegram)
+		tuples.WriteString(frame.Tuple() + "\n")
+
+		half := len(telegram) / 2
+		receivedAt := start.Add(time.Duration(counter) * 10 * time.Second)
+		if err = writer.WriteChunk(telegram[:half], receivedAt); err != nil {
+			t.Fatal(err)
+		}
+		if err = writer.WriteChunk(telegram[half:], receivedAt.Add(50*time.Millisecond)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	archives = make(map[string]string)
+	for name, content := range map[string][]byte{"raw": raw.Bytes(), "capture": capture.Bytes(),
+		"tuple": tuples.Bytes(), "empty": nil} {
+		archives[name] = filepath.Join(directory, name)
+		if err = ioutil.WriteFile(archives[name], content, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return archives
+}
+
+// Decrypts an archive into the given output format
+func decryptTestArchive(t *testing.T, archive, inputFormat, outputFormat string) (*archiveDecryptor, []byte) {
+	decryptor, err := smarty.NewDecryptor(testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decrypted bytes.Buffer
+	output, err := newOutput(outputFormat, &decrypted)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ad := &archiveDecryptor{decryptor: decryptor, tagLength: 12, output: output}
+	if err = ad.decryptArchive(archive, inputFormat); err != nil {
+		t.Fatal(err)
+	}
+	if err = output.flush(); err != nil {
+		t.Fatal(err)
+	}
+	return ad, decrypted.Bytes()
+}
+
+// Test if every archive format is decrypted, both detected and explicitly selected
+func TestDecryptArchive(t *testing.T) {
+	directory, err := ioutil.TempDir("", "archives")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	archives := writeArchives(t, directory)
+
+	for _, test := range []struct {
+		archive, format string
+	}{
+		{"raw", "auto"}, {"raw", "raw"},
+		{"capture", "auto"}, {"capture", "raw"},
+		{"tuple", "auto"}, {"tuple", "tuple"},
+	} {
+		ad, decrypted := decryptTestArchive(t, archives[test.archive], test.format, "text")
+		if ad.decrypted != 3 || ad.authFailures != 0 || ad.framingErrors != 0 {
+			t.Errorf("Archive %s as %s: %d telegrams decrypted, %d authentication failures, %d framing errors",
+				test.archive, test.format, ad.decrypted, ad.authFailures, ad.framingErrors)
+		}
+		if bytes.Count(decrypted, testPlainText) != 3 {
+			t.Errorf("Archive %s as %s: unexpected output %q", test.archive, test.format, decrypted)
+		}
+	}
+}
+
+// Test if the text, JSON and CSV outputs hold every telegram
+func TestOutputFormats(t *testing.T) {
+	directory, err := ioutil.TempDir("", "archives")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	archives := writeArchives(t, directory)
+
+	for _, test := range []struct {
+		format string
+		check  func(output []byte) bool
+	}{
+		{"text", func(output []byte) bool {
+			return bytes.Count(output, testPlainText) == 3
+		}},
+		{"json", func(output []byte) bool {
+			lines := strings.Split(strings.TrimSpace(string(output)), "\n")
+			for _, line := range lines {
+				var telegram jsonTelegram
+				if err := json.Unmarshal([]byte(line), &telegram); err != nil || len(telegram.Objects) != 1 ||
+					telegram.Objects[0] != (jsonObject{ID: "1-0:1.8.0", Value: "000123.456", Unit: "kWh"}) {
+					return false
+				}
+			}
+			return len(lines) == 3
+		}},
+		{"csv", func(output []byte) bool {
+			rows, err := csv.NewReader(bytes.NewReader(output)).ReadAll()
+			if err != nil || len(rows) != 4 || strings.Join(rows[0], ",") != strings.Join(csvHeader, ",") {
+				return false
+			}
+			for _, row := range rows[1:] {
+				if row[4] != "1-0:1.8.0" || row[5] != "000123.456" || row[6] != "kWh" {
+					return false
+				}
+			}
+			return true
+		}},
+	} {
+		if _, output := decryptTestArchive(t, archives["tuple"], "auto", test.format); !test.check(output) {
+			t.Errorf("Unexpected %s output: \n%s\n", test.format, output)
+		}
+	}
+
+	// Without any telegram, the CSV output still holds the columns
+	if _, output := decryptTestArchive(t, archives["empty"], "auto", "csv"); string(output) !=
+		strings.Join(csvHeader, ",")+"\n" {
+		t.Errorf("Unexpected CSV output of an empty archive: %q", output)
+	}
+}
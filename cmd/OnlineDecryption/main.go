@@ -22,8 +22,14 @@
 package main
 
 import (
+    "context"
+    "errors"
+    "os"
+    "os/signal"
+
     "github.com/NEXXTLAB/go-smarty-reader/cmd/util"
     "github.com/NEXXTLAB/go-smarty-reader/smarty"
+    "github.com/golang/glog"
 )
 
 func main() {
@@ -34,19 +40,44 @@ func main() {
     // Create a new smarty reader which will decrypt the telegrams after reading them
     // The serial connection is established right away
     // smartyObj is the object you may invoke methods on
-    smartyObj := smarty.NewOnlineDecryptor(*flags.Device, *flags.Key)
+    // Function defined in cmd/util/CommonSerialSetup.go
+    smartyObj, err := util.OnlineDecryptorSetup(flags)
+    if err != nil {
+        glog.Exitln(err)
+    }
+    // After use, remember to close to serial port!
+    defer smartyObj.Disconnect()
+
+    // Stop reading on Ctrl+C
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    interrupt := make(chan os.Signal, 1)
+    signal.Notify(interrupt, os.Interrupt)
+    go func() {
+        <-interrupt
+        cancel()
+    }()
+
+    // Read in the background, telegrams and errors are delivered over the returned channels
+    telegrams, errs := smartyObj.Stream(ctx, smarty.DefaultStreamOptions())
 
     // Read until 100 telegrams could be successfully decrypted
     for telegramCounter := 0; telegramCounter < 100; {
-        // Wait, get and decrypt the next telegram
-        plainText, ok := smartyObj.GetTelegram()
-        // If the decryption was successful, print the payload to the console
-        if ok {
-            println(string(plainText))
+        select {
+        case telegram, ok := <-telegrams:
+            if !ok {
+                return
+            }
+            // Print the decrypted payload to the console
+            println(string(telegram.PlainText))
             println("~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~")
             telegramCounter++
+        case err, ok := <-errs:
+            if !ok {
+                errs = nil
+            } else if !errors.Is(err, context.Canceled) {
+                glog.Errorln(err)
+            }
         }
     }
-    // After use, remember to close to serial port!
-    smartyObj.Disconnect()
 }
@@ -25,11 +25,16 @@
 package main
 
 import (
+	"context"
+	"errors"
 	"fmt"
+	"io"
 
 	"github.com/NEXXTLAB/go-smarty-reader/cmd/util"
+	"github.com/NEXXTLAB/go-smarty-reader/share"
 	"github.com/NEXXTLAB/go-smarty-reader/smarty"
-	"github.com/basvdlei/gotsmart/dsmr"
+	"github.com/NEXXTLAB/go-smarty-reader/smarty/obis"
+	"github.com/golang/glog"
 )
 
 func main() {
@@ -37,39 +42,80 @@ func main() {
 	// Function defined in cmd/util/CommonFlagParsing.go
 	flags := util.StartupFlagParsing()
 	fmt.Printf("Device to read from: %s\n", *flags.Device)
-	fmt.Printf("Decryption key: %s\n", *flags.Key)
 
 	// Preparing the MQTT connection
 	// Functions defined in cmd/util/CommonMqttSetup.go
-	client := util.MqttSetup(util.GetHostname(), flags.Mqtt)
+	client, err := util.MqttSetup(util.GetHostname(), flags.Mqtt)
+	if err != nil {
+		glog.Exitln(err)
+	}
+
+	// Text per OBIS code, JSON per OBIS code or JSON per telegram
+	payloadMode, err := share.ParsePayloadMode(*flags.Mqtt.Payload)
+	if err != nil {
+		glog.Exitln(err)
+	}
+
+	// Publish "stale" on <topicRoot>meter/status if the meter stops sending valid telegrams
+	// The reader itself reports "online" or "offline" on <topicRoot>status
+	meterAvailability := share.NewMeterAvailability(client, *flags.Mqtt.MeterInterval, *flags.Mqtt.StaleIntervals)
+	defer meterAvailability.Stop()
+
+	// Optionally let Home Assistant create a sensor for every published OBIS code
+	var discovery *share.DiscoveryPublisher
+	if *flags.Mqtt.Discovery {
+		discovery = share.NewDiscoveryPublisher(client, *flags.Mqtt.DiscoveryPrefix)
+		discovery.SetPayloadMode(payloadMode)
+		discovery.SetMeterAvailability(true)
+	}
 
 	// Create a new smarty reader which will decrypt the telegrams after reading them
 	// The serial connection is established right away
 	// smartyObj is the object you may invoke methods on
-	smartyObj := smarty.NewOnlineDecryptor(*flags.Device, *flags.Key)
+	// Function defined in cmd/util/CommonSerialSetup.go
+	smartyObj, err := util.OnlineDecryptorSetup(flags)
+	if err != nil {
+		glog.Exitln(err)
+	}
 
-	// Read until 100 telegrams could be successfully decrypted and published
+	// Read until 100 telegrams could be successfully decrypted and published, or the input ends
 	for telegramCounter := 0; telegramCounter < 100; {
 		// Wait, get and decrypt the next telegram
-		plainText, ok := smartyObj.GetTelegram()
+		telegram, err := smartyObj.ReadTelegram(context.Background())
 		// If the decryption was successful, print the payload to the console
-		if ok {
-			frame, err2 := dsmr.ParseFrame(string(plainText))
+		if err == nil {
+			reading, err2 := obis.Parse(telegram.PlainText)
 			if err2 == nil {
+				meterAvailability.Seen()
 				// Publish all present OBIS codes.
 				// Take note that timestamp, equipmentID, header and version are stored separately in this package.
-				// frame.Objects includes only measured data.
-				for _, token := range frame.Objects {
-					client.Publish(token.ID, token.Value, token.Unit, false, true)
+				// reading.Objects includes only measured data.
+				if discovery != nil {
+					discovery.Announce(reading)
 				}
+				// Unencrypted telegrams carry no frame counter, it is left out
+				frameCounter, _ := telegram.Counter()
+				client.PublishReading(reading, frameCounter, payloadMode, false, true)
 				telegramCounter++
 			} else {
 				fmt.Println(err2)
 			}
+		} else if errors.Is(err, smarty.ErrFraming) || errors.Is(err, smarty.ErrAuthFailed) ||
+			errors.Is(err, smarty.ErrReplay) {
+			// Only this telegram is dropped, reading goes on with the next one
+			glog.Errorln(err)
+		} else {
+			// The input ended (end of the replay) or the device failed, no further telegram will arrive
+			if err != io.EOF {
+				glog.Errorln(err)
+			}
+			break
 		}
 	}
 
 	// Close the MQTT connection
+	stats := client.QueueStats()
+	glog.Infof("MQTT messages: %d delayed, %d dropped, %d still queued\n", stats.Delayed, stats.Dropped, stats.Queued)
 	client.Disconnect(250)
 
 	// After use, remember to close to serial port!
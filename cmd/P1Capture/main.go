@@ -0,0 +1,104 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   Example on how to record the raw byte stream of the P1 port with receive timestamps (format described in
+   smarty/Capture.go), eg. for bug reports. The capture can be replayed by the decrypting examples:
+       go run ./cmd/P1Capture -device /dev/ttyUSB0 -output meter.p1cap -duration 1h
+       go run ./cmd/OnlineDecryption/main.go -key yourKey -replay meter.p1cap -replaySpeed 60
+*/
+
+package main
+
+import (
+	"context"
+	"flag"
+	"os"
+	"os/signal"
+	"sync/atomic"
+
+	"github.com/NEXXTLAB/go-smarty-reader/cmd/util"
+	"github.com/NEXXTLAB/go-smarty-reader/smarty"
+	"github.com/golang/glog"
+)
+
+func main() {
+
+	// Capture specific flags, parsed together with the common flags
+	output := flag.String("output", "p1.p1cap", "File to write the capture to, an existing file is replaced.")
+	duration := flag.Duration("duration", 0, "Time to capture, 0 to capture until Ctrl+C.")
+
+	// Function defined in cmd/util/CommonFlagParsing.go
+	flags := util.StartupFlagParsing()
+
+	// Function defined in cmd/util/CommonSerialSetup.go
+	serialConfig, err := util.SerialSetup(*flags.Device, flags.Serial)
+	if err != nil {
+		glog.Exitln(err)
+	}
+
+	// The telegrams are not decrypted, every byte read from the port is captured
+	smartyObj, err := smarty.NewCipherForwarderWithConfig(serialConfig)
+	if err != nil {
+		glog.Exitln(err)
+	}
+	smartyObj.SetTagLength(*flags.Security.TagLength)
+
+	file, err := os.Create(*output)
+	if err != nil {
+		glog.Exitln(err)
+	}
+	capture, err := smarty.NewCaptureWriter(file)
+	if err != nil {
+		glog.Exitln(err)
+	}
+	smartyObj.SetCapture(capture)
+
+	// Stop capturing on Ctrl+C or once the duration elapsed
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if *duration > 0 {
+		var cancelTimeout context.CancelFunc
+		ctx, cancelTimeout = context.WithTimeout(ctx, *duration)
+		defer cancelTimeout()
+	}
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	// Reading the telegrams keeps the capture going, they are only counted
+	// Unencrypted telegrams are captured as well, they are just not counted
+	var telegrams int64
+	err = smartyObj.Subscribe(ctx, smarty.DefaultStreamOptions(), func(telegram smarty.Telegram) {
+		glog.Infof("Telegram %d of %s captured\n", atomic.AddInt64(&telegrams, 1), telegram.SystemTitle)
+	})
+	if err != context.Canceled && err != context.DeadlineExceeded {
+		glog.Errorln(err)
+	}
+
+	// After use, remember to close to serial port!
+	// The reader may still be running, closing the capture waits for a chunk being written and rejects further ones
+	smartyObj.SetCapture(nil)
+	smartyObj.Disconnect()
+	if err = capture.Close(); err != nil {
+		glog.Errorln(err)
+	}
+	glog.Infof("%d telegrams captured to %s\n", atomic.LoadInt64(&telegrams), *output)
+}
@@ -0,0 +1,212 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   Example on how to decrypt telegrams centrally, forwarded by the RemoteForwarding example over TCP or MQTT
+   (protocol described in smarty/Forwarding.go). Only this host requires the decryption keys, eg.
+       go run ./cmd/RemoteDecryption -key yourKey -listen :2001
+       go run ./cmd/RemoteDecryption -keyring keys.txt -transport mqtt -mqttBroker tcp://broker.local:1883
+   The keyring holds the keys of several meters (format described in smarty/Keyring.go), it is reloaded on SIGHUP.
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"github.com/NEXXTLAB/go-smarty-reader/cmd/util"
+	"github.com/NEXXTLAB/go-smarty-reader/smarty"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/golang/glog"
+)
+
+func main() {
+
+	// Receiving specific flags, parsed together with the common flags
+	transport := flag.String("transport", "tcp", "Transport of the forwarded telegrams: tcp or mqtt.")
+	listen := flag.String("listen", ":2001", "TCP address to accept the forwarding senders on.")
+	keyringFile := flag.String("keyring", "", "File holding the keys of several meters, instead of -key.")
+	keyringEnv := flag.String("keyringEnv", "",
+		"Environment variable holding the keys of several meters (eg. SMARTY_KEYS), instead of -key.")
+
+	// Function defined in cmd/util/CommonFlagParsing.go
+	flags := util.StartupFlagParsing()
+
+	decryptor, err := openDecryptor(*flags.Key, *keyringFile, *keyringEnv, flags.Security)
+	if err != nil {
+		glog.Exitln(err)
+	}
+	receiver := &receiver{decryptor: decryptor, guard: smarty.NewReplayGuard()}
+
+	// Stop receiving on Ctrl+C
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	switch *transport {
+	case "tcp":
+		listener, err := net.Listen("tcp", *listen)
+		if err != nil {
+			glog.Exitln(err)
+		}
+		glog.Infof("Accepting forwarded telegrams on %s\n", listener.Addr())
+		go func() {
+			<-ctx.Done()
+			listener.Close()
+		}()
+		receiver.accept(listener)
+	case "mqtt":
+		// Function defined in cmd/util/CommonMqttSetup.go
+		connection, err := util.MqttSetup(util.GetHostname(), flags.Mqtt)
+		if err != nil {
+			glog.Exitln(err)
+		}
+		defer connection.Disconnect(250)
+		// The wildcard in place of the hostname receives the telegrams of every sender: <mqttTopicRoot>+/frames
+		if !connection.SubscribeTopic(*flags.Mqtt.TopicRoot+"+/frames", func(client mqtt.Client, message mqtt.Message) {
+			telegram, err := smarty.UnmarshalForwardedTelegram(message.Payload())
+			if err != nil {
+				glog.Errorf("Message on %s dropped: %s\n", message.Topic(), err)
+				return
+			}
+			receiver.decrypt(telegram)
+		}) {
+			glog.Exitln("Unable to subscribe to the forwarded telegrams")
+		}
+		<-ctx.Done()
+	default:
+		glog.Exitf("Unknown transport %q, expected tcp or mqtt\n", *transport)
+	}
+}
+
+// Decrypts the telegrams of a single meter (smarty.Decryptor) or of several meters (smarty.Keyring)
+type frameDecryptor interface {
+	DecryptFrame(frame smarty.Frame) (plainText []byte, err error)
+}
+
+// Returns the keyring if one is given, otherwise the decryptor of the single key
+func openDecryptor(key, keyringFile, keyringEnv string, securityInfo util.SecurityInfo) (frameDecryptor, error) {
+	// Function defined in cmd/util/CommonSecuritySetup.go
+	securityConfig, err := util.SecuritySetup(securityInfo)
+	if err != nil {
+		return nil, err
+	}
+	var keyring *smarty.Keyring
+	switch {
+	case keyringFile != "":
+		keyring, err = smarty.LoadKeyringFile(keyringFile, securityConfig)
+	case keyringEnv != "":
+		keyring, err = smarty.LoadKeyringEnv(keyringEnv, securityConfig)
+	default:
+		return smarty.NewDecryptorWithConfig(key, securityConfig)
+	}
+	if err != nil {
+		return nil, err
+	}
+	glog.Infof("Loaded the keys of %d meters\n", keyring.Len())
+
+	// Reload the keys on SIGHUP, eg. after adding a household
+	reload := make(chan os.Signal, 1)
+	signal.Notify(reload, syscall.SIGHUP)
+	go func() {
+		for range reload {
+			if err := keyring.Reload(); err != nil {
+				glog.Errorf("Keeping the previous keys: %s\n", err)
+				continue
+			}
+			glog.Infof("Reloaded the keys of %d meters\n", keyring.Len())
+		}
+	}()
+	return keyring, nil
+}
+
+// Struct decrypting the telegrams of all senders
+// The ReplayGuard keeps track of the frame counter per system title, a sender can not replay older telegrams.
+type receiver struct {
+	decryptor frameDecryptor
+	guard     *smarty.ReplayGuard
+}
+
+func (r *receiver) accept(listener net.Listener) {
+	for {
+		connection, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		go r.serve(connection)
+	}
+}
+
+// Reads the telegrams of a single sender until it disconnects
+func (r *receiver) serve(connection net.Conn) {
+	defer connection.Close()
+	glog.Infof("Sender %s connected\n", connection.RemoteAddr())
+	reader := smarty.NewForwardingReader(connection)
+	for {
+		telegram, err := reader.ReadTelegram()
+		switch {
+		case errors.Is(err, smarty.ErrFraming):
+			// The stream of a sender sending malformed messages may be out of sync
+			glog.Errorf("Sender %s dropped, malformed message: %s\n", connection.RemoteAddr(), err)
+			return
+		case err == io.EOF:
+			glog.Infof("Sender %s disconnected\n", connection.RemoteAddr())
+			return
+		case err != nil:
+			glog.Errorf("Sender %s dropped: %s\n", connection.RemoteAddr(), err)
+			return
+		default:
+			r.decrypt(telegram)
+		}
+	}
+}
+
+// Called by one goroutine per sender, the same telegram sent by several senders at once is only decrypted once
+func (r *receiver) decrypt(telegram smarty.Telegram) {
+	plainText, err := r.decryptor.DecryptFrame(telegram.Frame)
+	if err != nil {
+		glog.Errorf("Telegram of %s dropped: %s\n", telegram.SystemTitle, err)
+		return
+	}
+	// The frame counter is checked once authenticated, and remembered within the same lock
+	// Telegrams without authentication (0x20) could be forged, their frame counter is not remembered
+	check := r.guard.CheckAndAccept
+	if telegram.SecurityControl&smarty.SecurityAuthentication == 0 {
+		check = r.guard.Check
+	}
+	if _, err = check(telegram.Frame); err != nil {
+		glog.Errorf("Telegram of %s dropped: %s\n", telegram.SystemTitle, err)
+		return
+	}
+
+	// Print the decrypted payload to the console, a single write keeps the telegrams of several senders apart
+	fmt.Printf("%s (received %s)\n%s\n~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~~\n",
+		telegram.SystemTitle, telegram.ReceivedAt.Format("2006-01-02 15:04:05"), plainText)
+}
@@ -0,0 +1,162 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   Example on how to forward the encrypted telegrams to a remote decryptor (see the RemoteDecryption example), the
+   device attached to the meter does not need the decryption key. The telegrams are sent over TCP or MQTT using the
+   forwarding protocol described in smarty/Forwarding.go, eg.
+       go run ./cmd/RemoteDecryption -key yourKey -listen :2001
+       go run ./cmd/RemoteForwarding -device /dev/ttyUSB0 -remote decryptor.local:2001
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"net"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/NEXXTLAB/go-smarty-reader/cmd/util"
+	"github.com/NEXXTLAB/go-smarty-reader/smarty"
+	"github.com/golang/glog"
+)
+
+func main() {
+
+	// Forwarding specific flags, parsed together with the common flags
+	transport := flag.String("transport", "tcp", "Transport to the remote decryptor: tcp or mqtt.")
+	remote := flag.String("remote", "", "TCP address of the remote decryptor (eg. decryptor.local:2001).")
+
+	// Function defined in cmd/util/CommonFlagParsing.go
+	flags := util.StartupFlagParsing()
+
+	send, closeTransport, err := openTransport(*transport, *remote, flags.Mqtt)
+	if err != nil {
+		glog.Exitln(err)
+	}
+	defer closeTransport()
+
+	// Function defined in cmd/util/CommonSerialSetup.go
+	serialConfig, err := util.SerialSetup(*flags.Device, flags.Serial)
+	if err != nil {
+		glog.Exitln(err)
+	}
+
+	// The telegrams are not decrypted, no key is required on this device
+	smartyObj, err := smarty.NewCipherForwarderWithConfig(serialConfig)
+	if err != nil {
+		glog.Exitln(err)
+	}
+	// After use, remember to close to serial port!
+	defer smartyObj.Disconnect()
+	smartyObj.SetTagLength(*flags.Security.TagLength)
+
+	// Stop forwarding on Ctrl+C
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		cancel()
+	}()
+
+	err = smartyObj.Subscribe(ctx, smarty.DefaultStreamOptions(), func(telegram smarty.Telegram) {
+		if !telegram.Encrypted() {
+			glog.Warningln("Unencrypted telegram dropped, the forwarding protocol only carries encrypted telegrams")
+			return
+		}
+		if err := send(telegram); err != nil {
+			glog.Errorf("Telegram of %s dropped: %s\n", telegram.SystemTitle, err)
+			return
+		}
+		glog.Infof("Telegram of %s forwarded\n", telegram.SystemTitle)
+	})
+	if err != context.Canceled {
+		glog.Errorln(err)
+	}
+}
+
+// Connects to the remote decryptor, returns the function sending a single telegram
+func openTransport(transport, remote string, mqttInfo util.MqttInfo) (send func(smarty.Telegram) error,
+	closeTransport func(), err error) {
+	switch transport {
+	case "tcp":
+		if remote == "" {
+			glog.Exitln("The tcp transport requires the -remote address")
+		}
+		sender := &tcpSender{address: remote}
+		return sender.send, sender.close, nil
+	case "mqtt":
+		// Function defined in cmd/util/CommonMqttSetup.go
+		// The telegrams are published to <mqttTopicRoot><hostname>/frames
+		connection, err := util.MqttSetup(util.GetHostname(), mqttInfo)
+		if err != nil {
+			return nil, nil, err
+		}
+		return func(telegram smarty.Telegram) error {
+			message, err := smarty.MarshalForwardedTelegram(telegram)
+			if err != nil {
+				return err
+			}
+			if !connection.PublishBinary("frames", message, false) {
+				return errPublish
+			}
+			return nil
+		}, func() { connection.Disconnect(250) }, nil
+	}
+	glog.Exitf("Unknown transport %q, expected tcp or mqtt\n", transport)
+	return nil, nil, nil
+}
+
+var errPublish = errors.New("unable to publish the telegram")
+
+// Struct sending the telegrams over TCP, the connection is (re)established by the next telegram once it failed
+type tcpSender struct {
+	address    string
+	connection net.Conn
+	writer     *smarty.ForwardingWriter
+}
+
+func (ts *tcpSender) send(telegram smarty.Telegram) error {
+	if ts.connection == nil {
+		connection, err := net.DialTimeout("tcp", ts.address, 5*time.Second)
+		if err != nil {
+			return err
+		}
+		glog.Infof("Connected to %s\n", ts.address)
+		ts.connection = connection
+		ts.writer = smarty.NewForwardingWriter(connection)
+	}
+	ts.connection.SetWriteDeadline(time.Now().Add(5 * time.Second))
+	if err := ts.writer.WriteTelegram(telegram); err != nil {
+		ts.close()
+		return err
+	}
+	return nil
+}
+
+func (ts *tcpSender) close() {
+	if ts.connection != nil {
+		ts.connection.Close()
+		ts.connection = nil
+	}
+}
@@ -0,0 +1,98 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   Faults which can be injected into the telegram stream, to test how a reader copes with a misbehaving meter or
+   a bad connection.
+*/
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+)
+
+type fault string
+
+const (
+	// Only the first half of the telegram is sent
+	faultTruncate fault = "truncate"
+	// The separator 0x82 following the system title is replaced
+	faultSeparator fault = "separator"
+	// The gcm tag is modified, the telegram fails authentication
+	faultTag fault = "tag"
+	// The frame counter jumps back
+	faultRollback fault = "rollback"
+)
+
+var knownFaults = []fault{faultTruncate, faultSeparator, faultTag, faultRollback}
+
+// Struct deciding which fault, if any, to inject into the next telegram
+type faultInjector struct {
+	faults []fault
+	rate   float64
+	random *rand.Rand
+}
+
+func newFaultInjector(faultList string, rate float64, random *rand.Rand) (*faultInjector, error) {
+	injector := &faultInjector{rate: rate, random: random}
+	for _, name := range strings.Split(faultList, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !isKnownFault(fault(name)) {
+			return nil, fmt.Errorf("unknown fault %q, known faults: %v", name, knownFaults)
+		}
+		injector.faults = append(injector.faults, fault(name))
+	}
+	return injector, nil
+}
+
+func isKnownFault(f fault) bool {
+	for _, known := range knownFaults {
+		if f == known {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the fault to inject into the next telegram, or an empty string
+func (fi *faultInjector) next() fault {
+	if len(fi.faults) == 0 || fi.random.Float64() >= fi.rate {
+		return ""
+	}
+	return fi.faults[fi.random.Intn(len(fi.faults))]
+}
+
+// Modifies the encoded telegram according to the fault
+// Rollbacks are handled while encoding, since they concern the frame counter.
+func applyFault(f fault, telegram []byte) []byte {
+	switch f {
+	case faultTruncate:
+		return telegram[:len(telegram)/2]
+	case faultSeparator:
+		// Start byte, system title length, system title
+		telegram[2+int(telegram[1])] = 0x00
+	case faultTag:
+		telegram[len(telegram)-1] ^= 0xFF
+	}
+	return telegram
+}
@@ -0,0 +1,132 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   The simulated meter generates plain text telegrams in the format of the Luxembourgian smarty. The energy
+   counters increase with the randomized phase power, the checksum is computed over every telegram.
+*/
+
+package main
+
+import (
+	"bytes"
+	"encoding/hex"
+	"fmt"
+	"math/rand"
+	"time"
+
+	"github.com/NEXXTLAB/go-smarty-reader/smarty/obis"
+)
+
+const phases = 3
+
+// Struct holding the state of the simulated meter
+type meter struct {
+	equipmentID                    string
+	energyImport, energyExport     float64
+	reactiveImport, reactiveExport float64
+	powerFailures                  int
+	location                       *time.Location
+	random                         *rand.Rand
+}
+
+func newMeter(equipmentID string) *meter {
+	// The meter sends its local time, fall back to a fixed offset if no time zone database is available
+	location, err := time.LoadLocation("Europe/Luxembourg")
+	if err != nil {
+		location = time.FixedZone("CET", 1*60*60)
+	}
+	return &meter{
+		equipmentID:    equipmentID,
+		energyImport:   1234.567,
+		energyExport:   89.012,
+		reactiveImport: 123.456,
+		reactiveExport: 45.678,
+		powerFailures:  3,
+		location:       location,
+		random:         rand.New(rand.NewSource(time.Now().UnixNano())),
+	}
+}
+
+// Generates the next telegram, the counters advance by the power drawn during the elapsed interval
+func (m *meter) telegram(now time.Time, interval time.Duration) []byte {
+	var powerImport, powerExport, voltage, current [phases]float64
+	var totalImport, totalExport float64
+	for phase := 0; phase < phases; phase++ {
+		// Some phases feed in, eg. from solar panels
+		if m.random.Float64() < 0.2 {
+			powerExport[phase] = m.random.Float64() * 2
+		} else {
+			powerImport[phase] = 0.05 + m.random.Float64()*2.5
+		}
+		voltage[phase] = 227 + m.random.Float64()*6
+		current[phase] = (powerImport[phase] + powerExport[phase]) * 1000 / voltage[phase]
+		totalImport += powerImport[phase]
+		totalExport += powerExport[phase]
+	}
+	hours := interval.Hours()
+	m.energyImport += totalImport * hours
+	m.energyExport += totalExport * hours
+	m.reactiveImport += totalImport * 0.1 * hours
+	m.reactiveExport += totalExport * 0.05 * hours
+
+	var body bytes.Buffer
+	fmt.Fprint(&body, "/Lux5\\253663629_D\r\n\r\n")
+	fmt.Fprint(&body, "1-3:0.2.8(42)\r\n")
+	fmt.Fprintf(&body, "0-0:1.0.0(%s)\r\n", m.timestamp(now))
+	fmt.Fprintf(&body, "0-0:42.0.0(%s)\r\n", hex.EncodeToString([]byte(m.equipmentID)))
+	fmt.Fprintf(&body, "1-0:1.8.0(%010.3f*kWh)\r\n", m.energyImport)
+	fmt.Fprintf(&body, "1-0:2.8.0(%010.3f*kWh)\r\n", m.energyExport)
+	fmt.Fprintf(&body, "1-0:3.8.0(%010.3f*kvarh)\r\n", m.reactiveImport)
+	fmt.Fprintf(&body, "1-0:4.8.0(%010.3f*kvarh)\r\n", m.reactiveExport)
+	fmt.Fprintf(&body, "1-0:1.7.0(%06.3f*kW)\r\n", totalImport)
+	fmt.Fprintf(&body, "1-0:2.7.0(%06.3f*kW)\r\n", totalExport)
+	fmt.Fprintf(&body, "1-0:3.7.0(%06.3f)\r\n", totalImport*0.1)
+	fmt.Fprintf(&body, "1-0:4.7.0(%06.3f)\r\n", totalExport*0.05)
+	fmt.Fprint(&body, "0-0:17.0.0(77.376)\r\n")
+	fmt.Fprint(&body, "0-0:96.3.10(1)\r\n")
+	fmt.Fprintf(&body, "0-0:96.7.21(%05d)\r\n", m.powerFailures)
+	for _, id := range []string{"32.32.0", "52.32.0", "72.32.0", "32.36.0", "52.36.0", "72.36.0"} {
+		fmt.Fprintf(&body, "1-0:%s(00000)\r\n", id)
+	}
+	for _, id := range []string{"96.13.0", "96.13.2", "96.13.3", "96.13.4", "96.13.5"} {
+		fmt.Fprintf(&body, "0-0:%s()\r\n", id)
+	}
+	for phase, group := range []int{20, 40, 60} {
+		fmt.Fprintf(&body, "1-0:%d.7.0(%06.3f*kW)\r\n", group+1, powerImport[phase])
+		fmt.Fprintf(&body, "1-0:%d.7.0(%06.3f*kW)\r\n", group+2, powerExport[phase])
+	}
+	for phase, group := range []int{32, 52, 72} {
+		fmt.Fprintf(&body, "1-0:%d.7.0(%05.1f*V)\r\n", group, voltage[phase])
+	}
+	for phase, group := range []int{31, 51, 71} {
+		fmt.Fprintf(&body, "1-0:%d.7.0(%03.0f*A)\r\n", group, current[phase])
+	}
+	body.WriteString("!")
+	fmt.Fprintf(&body, "%04X\r\n", obis.Checksum(body.Bytes()))
+	return body.Bytes()
+}
+
+// Local time in the format YYMMDDhhmmss, followed by 'S' for summer and 'W' for winter time
+func (m *meter) timestamp(now time.Time) string {
+	local := now.In(m.location)
+	season := "W"
+	if local.IsDST() {
+		season = "S"
+	}
+	return local.Format("060102150405") + season
+}
@@ -0,0 +1,97 @@
+//go:build linux
+// +build linux
+
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   Creation of the pseudo-terminal the simulated meter writes to. The reader opens the slave side like any
+   serial device.
+*/
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+	"unsafe"
+)
+
+// Opens a new pseudo-terminal
+// Return:
+// * master: the side the simulator writes to
+// * slave: the side kept open by the simulator, so the written telegrams are buffered until a reader connects
+// * slaveName: the device path to pass to the reader, eg. /dev/pts/3
+func openTerminal() (master, slave *os.File, slaveName string, err error) {
+	master, err = os.OpenFile("/dev/ptmx", os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		return nil, nil, "", err
+	}
+
+	var unlock int32
+	if err = ioctl(master, syscall.TIOCSPTLCK, uintptr(unsafe.Pointer(&unlock))); err != nil {
+		master.Close()
+		return nil, nil, "", fmt.Errorf("unable to unlock the pseudo-terminal: %v", err)
+	}
+	var number uint32
+	if err = ioctl(master, syscall.TIOCGPTN, uintptr(unsafe.Pointer(&number))); err != nil {
+		master.Close()
+		return nil, nil, "", fmt.Errorf("unable to get the pseudo-terminal number: %v", err)
+	}
+	slaveName = fmt.Sprintf("/dev/pts/%d", number)
+
+	slave, err = os.OpenFile(slaveName, os.O_RDWR|syscall.O_NOCTTY, 0)
+	if err != nil {
+		master.Close()
+		return nil, nil, "", err
+	}
+	// Raw mode, the binary telegrams must not be altered by line processing
+	var termios syscall.Termios
+	if err = ioctl(slave, syscall.TCGETS, uintptr(unsafe.Pointer(&termios))); err == nil {
+		termios.Iflag = syscall.IGNPAR
+		termios.Oflag = 0
+		termios.Lflag = 0
+		termios.Cflag |= syscall.CS8 | syscall.CREAD | syscall.CLOCAL
+		err = ioctl(slave, syscall.TCSETS, uintptr(unsafe.Pointer(&termios)))
+	}
+	if err != nil {
+		slave.Close()
+		master.Close()
+		return nil, nil, "", fmt.Errorf("unable to set the pseudo-terminal to raw mode: %v", err)
+	}
+	return master, slave, slaveName, nil
+}
+
+// Calls the ioctl on the file descriptor, without File.Fd putting it into blocking mode which would disable the
+// write deadlines of the master
+func ioctl(file *os.File, request, argument uintptr) error {
+	conn, err := file.SyscallConn()
+	if err != nil {
+		return err
+	}
+	var errno syscall.Errno
+	if err = conn.Control(func(fd uintptr) {
+		_, _, errno = syscall.Syscall(syscall.SYS_IOCTL, fd, request, argument)
+	}); err != nil {
+		return err
+	}
+	if errno != 0 {
+		return errno
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   Virtual smarty meter, emitting an encrypted telegram every 10 seconds, either on a pseudo-terminal (Linux) or to
+   every client connected over TCP. It allows running the other examples without P1 cable, eg.
+       go run ./cmd/SmartySimulator -key yourKey -device /tmp/smarty
+       go run ./cmd/OnlineDecryption/main.go -key yourKey -device /tmp/smarty
+   Faults such as truncated telegrams, bad separators, wrong tags and frame counter rollbacks can be injected.
+*/
+
+package main
+
+import (
+	"encoding/hex"
+	"errors"
+	"flag"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/NEXXTLAB/go-smarty-reader/cmd/util"
+	"github.com/NEXXTLAB/go-smarty-reader/smarty"
+	"github.com/golang/glog"
+)
+
+func main() {
+
+	// Simulator specific flags, parsed together with the common flags
+	listen := flag.String("listen", "",
+		"TCP address to serve the telegrams on (eg. :2000), instead of a pseudo-terminal.")
+	interval := flag.Duration("interval", 10*time.Second, "Time between two telegrams.")
+	systemTitle := flag.String("systemTitle", "5341476770000001", "System title of the meter (8 bytes, hex).")
+	equipmentID := flag.String("equipmentID", "SAG1030700000001", "Equipment identifier of the meter.")
+	frameCounter := flag.Uint("frameCounter", 1, "Frame counter of the first telegram.")
+	faults := flag.String("faults", "",
+		"Comma separated faults to inject: truncate, separator, tag, rollback.")
+	faultRate := flag.Float64("faultRate", 0.1, "Probability of a telegram being faulty.")
+
+	// Function defined in cmd/util/CommonFlagParsing.go
+	// The device flag is optional, a symbolic link to the pseudo-terminal is created at the given path
+	flags := util.StartupFlagParsing()
+
+	title, err := hex.DecodeString(*systemTitle)
+	if err != nil {
+		glog.Exitf("Invalid system title: %s\n", err)
+	}
+	encoder, err := smarty.NewEncoder(*flags.Key, title)
+	if err != nil {
+		glog.Exitln(err)
+	}
+
+	output, closeOutput, err := openOutput(*listen, *flags.Device)
+	if err != nil {
+		glog.Exitln(err)
+	}
+
+	// Shutdown must not wait for a write, the output is closed by the signal handler and the loop stops afterwards
+	stop := make(chan struct{})
+	var stopOnce sync.Once
+	shutdown := func() {
+		stopOnce.Do(func() {
+			close(stop)
+			closeOutput()
+		})
+	}
+	defer shutdown()
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-interrupt
+		shutdown()
+	}()
+
+	simulatedMeter := newMeter(*equipmentID)
+	injector, err := newFaultInjector(*faults, *faultRate, simulatedMeter.random)
+	if err != nil {
+		glog.Exitln(err)
+	}
+
+	ticker := time.NewTicker(*interval)
+	defer ticker.Stop()
+
+	counter := uint32(*frameCounter)
+	for {
+		plainText := simulatedMeter.telegram(time.Now(), *interval)
+
+		fault := injector.next()
+		if fault == faultRollback && counter < 2 {
+			// No frame counter below the previous telegram, send this one without fault
+			fault = ""
+		}
+		usedCounter := counter
+		switch {
+		case fault == faultRollback && counter > 10:
+			usedCounter = counter - 10
+		case fault == faultRollback:
+			usedCounter = 0
+		default:
+			counter++
+		}
+		telegram, err := encoder.Encode(plainText, usedCounter)
+		if err != nil {
+			glog.Exitln(err)
+		}
+		telegram = applyFault(fault, telegram)
+
+		_, err = output.Write(telegram)
+		select {
+		case <-stop:
+			return
+		default:
+		}
+		if errors.Is(err, errNoReader) {
+			glog.Warningf("Telegram %d dropped: %s\n", usedCounter, err)
+		} else if err != nil {
+			glog.Errorf("Unable to write telegram: %s\n", err)
+		} else if fault != "" {
+			glog.Infof("Sent telegram %d with fault %s\n", usedCounter, fault)
+		} else {
+			glog.Infof("Sent telegram %d\n", usedCounter)
+		}
+
+		select {
+		case <-ticker.C:
+		case <-stop:
+			return
+		}
+	}
+}
+
+// Opens the pseudo-terminal, or starts listening for TCP clients if an address is given
+func openOutput(listenAddress, linkName string) (output io.Writer, closeOutput func(), err error) {
+	if listenAddress != "" {
+		listener, err := net.Listen("tcp", listenAddress)
+		if err != nil {
+			return nil, nil, err
+		}
+		glog.Infof("Serving telegrams on %s\n", listener.Addr())
+		clients := &broadcaster{}
+		go clients.accept(listener)
+		return clients, func() { listener.Close() }, nil
+	}
+
+	master, slave, slaveName, err := openTerminal()
+	if err != nil {
+		return nil, nil, err
+	}
+	glog.Infof("Serving telegrams on %s\n", slaveName)
+	if linkName != "" {
+		os.Remove(linkName)
+		if err = os.Symlink(slaveName, linkName); err != nil {
+			return nil, nil, err
+		}
+		glog.Infof("Linked %s to %s\n", linkName, slaveName)
+	}
+	return &terminal{master: master}, func() {
+		if linkName != "" {
+			os.Remove(linkName)
+		}
+		slave.Close()
+		master.Close()
+	}, nil
+}
+
+// Time a write may block before the telegram is dropped
+const writeTimeout = time.Second
+
+var errNoReader = errors.New("no reader drains the pseudo-terminal")
+
+// Struct writing to the pseudo-terminal, its buffer fills up while no reader drains the slave side
+type terminal struct {
+	master *os.File
+}
+
+// Writes the telegram, returning errNoReader instead of blocking once the buffer is full
+func (t *terminal) Write(p []byte) (n int, err error) {
+	t.master.SetWriteDeadline(time.Now().Add(writeTimeout))
+	n, err = t.master.Write(p)
+	if os.IsTimeout(err) {
+		return n, errNoReader
+	}
+	return n, err
+}
+
+// Struct writing to all connected TCP clients, similar to ser2net
+type broadcaster struct {
+	mutex   sync.Mutex
+	clients []net.Conn
+}
+
+func (b *broadcaster) accept(listener net.Listener) {
+	for {
+		client, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		glog.Infof("Client %s connected\n", client.RemoteAddr())
+		b.mutex.Lock()
+		b.clients = append(b.clients, client)
+		b.mutex.Unlock()
+	}
+}
+
+// Writes to every client, dropping those which can not keep up or disconnected
+func (b *broadcaster) Write(p []byte) (n int, err error) {
+	b.mutex.Lock()
+	defer b.mutex.Unlock()
+	connected := b.clients[:0]
+	for _, client := range b.clients {
+		client.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := client.Write(p); err != nil {
+			glog.Infof("Client %s disconnected\n", client.RemoteAddr())
+			client.Close()
+			continue
+		}
+		connected = append(connected, client)
+	}
+	b.clients = connected
+	return len(p), nil
+}
@@ -0,0 +1,144 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"errors"
+	"io"
+	"math/rand"
+	"testing"
+	"time"
+
+	"github.com/NEXXTLAB/go-smarty-reader/smarty"
+	"github.com/NEXXTLAB/go-smarty-reader/smarty/obis"
+)
+
+const testKey = "000102030405060708090A0B0C0D0E0F"
+
+func newTestEncoder(t *testing.T) *smarty.Encoder {
+	encoder, err := smarty.NewEncoder(testKey, []byte("SAGgp\x00\x00\x01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return encoder
+}
+
+// Decrypts the single telegram written by the simulator
+func readTestTelegram(t *testing.T, telegram []byte) (smarty.Telegram, error) {
+	decryptor, err := smarty.NewOnlineDecryptorFromReader(bytes.NewReader(telegram), testKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return decryptor.ReadTelegram(context.Background())
+}
+
+func TestSimulatedTelegrams(t *testing.T) {
+	simulatedMeter := newMeter("SAG1030700000001")
+	encoder := newTestEncoder(t)
+	now := time.Now()
+	var previous float64
+	for counter := uint32(1); counter <= 3; counter++ {
+		telegram, err := encoder.Encode(simulatedMeter.telegram(now, 10*time.Second), counter)
+		if err != nil {
+			t.Fatal(err)
+		}
+		decrypted, err := readTestTelegram(t, telegram)
+		if err != nil {
+			t.Fatalf("telegram %d: %v", counter, err)
+		}
+		if frameCounter := binary.BigEndian.Uint32(decrypted.FrameCounter); frameCounter != counter {
+			t.Errorf("frame counter %d, expected %d", frameCounter, counter)
+		}
+		reading, err := obis.Parse(decrypted.PlainText)
+		if err != nil {
+			t.Fatalf("telegram %d: %v", counter, err)
+		}
+		if reading.EquipmentID != "SAG1030700000001" {
+			t.Errorf("equipment identifier %q", reading.EquipmentID)
+		}
+		if reading.EnergyImport < previous {
+			t.Errorf("energy import went back from %f to %f", previous, reading.EnergyImport)
+		}
+		previous = reading.EnergyImport
+		now = now.Add(10 * time.Second)
+	}
+}
+
+func TestFaults(t *testing.T) {
+	simulatedMeter := newMeter("SAG1030700000001")
+	encoder := newTestEncoder(t)
+	for _, test := range []struct {
+		fault fault
+		err   error
+	}{
+		{"", nil},
+		{faultTruncate, io.ErrUnexpectedEOF},
+		{faultSeparator, smarty.ErrFraming},
+		{faultTag, smarty.ErrAuthFailed},
+	} {
+		telegram, err := encoder.Encode(simulatedMeter.telegram(time.Now(), 10*time.Second), 1)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, err = readTestTelegram(t, applyFault(test.fault, telegram))
+		if !errors.Is(err, test.err) {
+			t.Errorf("fault %q: error %v, expected %v", test.fault, err, test.err)
+		}
+	}
+}
+
+func TestFaultInjector(t *testing.T) {
+	if _, err := newFaultInjector("tag,bitflip", 1, rand.New(rand.NewSource(1))); err == nil {
+		t.Error("unknown fault accepted")
+	}
+	injector, err := newFaultInjector(" tag, ,truncate", 1, rand.New(rand.NewSource(1)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		if f := injector.next(); f != faultTag && f != faultTruncate {
+			t.Fatalf("fault %q, expected tag or truncate", f)
+		}
+	}
+	injector.rate = 0
+	if f := injector.next(); f != "" {
+		t.Errorf("fault %q injected at rate 0", f)
+	}
+}
+
+// Without reader the pseudo-terminal must drop the telegrams instead of blocking the simulator
+func TestTerminalWithoutReader(t *testing.T) {
+	output, closeOutput, err := openOutput("", "")
+	if err != nil {
+		t.Skip("no pseudo-terminal:", err)
+	}
+	defer closeOutput()
+
+	telegram := make([]byte, 1024)
+	for i := 0; i < 1024; i++ {
+		if _, err = output.Write(telegram); err != nil {
+			break
+		}
+	}
+	if !errors.Is(err, errNoReader) {
+		t.Fatalf("error %v, expected %v", err, errNoReader)
+	}
+}
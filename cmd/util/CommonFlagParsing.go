@@ -23,15 +23,23 @@ package util
 
 import (
 	"flag"
+	"time"
 
+	"github.com/NEXXTLAB/go-smarty-reader/share"
+	"github.com/NEXXTLAB/go-smarty-reader/smarty"
 	"github.com/golang/glog"
 )
 
 // Struct holding startup flag values
 type Flag struct {
-	Device *string
-	Key    *string
-	Mqtt   MqttInfo
+	Device   *string
+	Key      *string
+	KeyFrom  KeyInfo
+	Mode     *string
+	Serial   SerialInfo
+	Replay   ReplayInfo
+	Security SecurityInfo
+	Mqtt     MqttInfo
 }
 
 func StartupFlagParsing() (flags Flag) {
@@ -41,7 +49,46 @@ func StartupFlagParsing() (flags Flag) {
 	// Define flags
 	flags = Flag{
 		Device: flag.String("device", "", "Serial device to read P1 data from."),
-		Key:    flag.String("key", "", "Decryption Key to use."),
+		Key: flag.String("key", "",
+			"Decryption Key to use. Visible to other users in the process list, prefer -keyFile, -keyEnv, "+
+				"-keyCredential or -keyStdin."),
+		KeyFrom: KeyInfo{
+			File: flag.String("keyFile", "",
+				"File holding the decryption key, it must not be accessible by other users (chmod 600)."),
+			Env: flag.String("keyEnv", "", "Environment variable holding the decryption key (eg. SMARTY_KEY)."),
+			Credential: flag.String("keyCredential", "",
+				"Name of the systemd credential holding the decryption key (LoadCredential=)."),
+			Stdin: flag.Bool("keyStdin", false, "Read the decryption key from the first line of stdin."),
+		},
+		Mode: flag.String("mode", "encrypted",
+			"Telegram format: encrypted (smarty), plaintext (DSMR 4/5) or auto to detect it."),
+		Serial: SerialInfo{
+			Baud: flag.Int("baud", 115200,
+				"Serial baud rate, 115200 for smarty and DSMR 4/5 meters, 9600 for older DSMR meters."),
+			Format: flag.String("serialFormat", "8N1",
+				"Serial data bits, parity and stop bits, 8N1 for smarty and DSMR 4/5 meters, 7E1 for older DSMR meters."),
+			ReadTimeout: flag.Duration("readTimeout", 500*time.Millisecond,
+				"Maximum time a serial read blocks before checking for shutdown."),
+			AutoProbe: flag.Bool("autoProbe", false,
+				"Probe the common serial settings until a telegram is recognised, instead of -baud and -serialFormat."),
+			NonInverted: flag.Bool("nonInverted", false,
+				"Hint that the P1 cable or adapter does not invert the signal, extends the probe error message."),
+		},
+		Replay: ReplayInfo{
+			File: flag.String("replay", "",
+				"Capture file (see the P1Capture example) to read from instead of the serial device."),
+			Speed: flag.Float64("replaySpeed", 1,
+				"Replay speed of the capture, 1 for real time, 10 for ten times faster, 0 without waiting."),
+		},
+		Security: SecurityInfo{
+			AuthenticationKey: flag.String("authKey", "00112233445566778899AABBCCDDEEFF",
+				"Authentication key (AK) of the meter, the default is the one of the smarty."),
+			TagLength: flag.Int("tagLength", 12,
+				"Length of the authentication tag in bytes, 12 for the smarty or 16."),
+			SecurityControl: flag.Uint("securityControl", 0x30,
+				"Expected security control byte: 0x10 (authentication), 0x20 (encryption), 0x30 (both) "+
+					"or 0 to accept any."),
+		},
 		Mqtt: MqttInfo{
 			Broker: flag.String("mqttBroker", "ssl://iot.eclipse.org:8883",
 				"MQTT Broker Address including protocol and port."),
@@ -49,6 +96,31 @@ func StartupFlagParsing() (flags Flag) {
 				"MQTT Base topic, extended by extensions (such as OBIS codes) during publish."),
 			Qos: flag.Int("mqttQos", 2,
 				"MQTT Quality of service level."),
+			Payload: flag.String("mqttPayload", "text",
+				"Payload format: text per OBIS code (\"6.695 kWh\"), json per OBIS code ({value, unit, ts}) "+
+					"or telegram for a single JSON document per telegram."),
+			QueueLimit: flag.Int("mqttQueue", share.DefaultQueueLimit,
+				"Number of messages kept while the MQTT broker is unreachable, the oldest are dropped first."),
+			ClientID: flag.String("mqttClientID", "",
+				"MQTT client ID, unique per broker. A random ID is used if empty."),
+			Username: flag.String("mqttUsername", "", "Username to authenticate at the MQTT broker."),
+			Password: flag.String("mqttPassword", "",
+				"Password to authenticate at the MQTT broker. Visible in the process list, prefer -mqttPasswordFile."),
+			PasswordFile: flag.String("mqttPasswordFile", "", "File holding the password of the MQTT broker."),
+			CAFile: flag.String("mqttCAFile", "",
+				"PEM bundle of the certificate authorities to trust for the MQTT broker, the system ones if empty."),
+			CertFile: flag.String("mqttCertFile", "", "PEM client certificate, for brokers requiring mutual TLS."),
+			KeyFile:  flag.String("mqttKeyFile", "", "PEM private key of the client certificate."),
+			Insecure: flag.Bool("mqttInsecure", false,
+				"Skip verifying the certificate of the MQTT broker, only for lab brokers."),
+			MeterInterval: flag.Duration("meterInterval", 10*time.Second,
+				"Time between two telegrams of the meter, 10s for the smarty."),
+			StaleIntervals: flag.Int("staleIntervals", 3,
+				"Number of intervals without valid telegram until the meter status is published as stale."),
+			Discovery: flag.Bool("haDiscovery", false,
+				"Announce the published OBIS codes to Home Assistant using MQTT discovery."),
+			DiscoveryPrefix: flag.String("haDiscoveryPrefix", "homeassistant",
+				"Discovery prefix configured in Home Assistant."),
 		},
 	}
 
@@ -56,13 +128,28 @@ func StartupFlagParsing() (flags Flag) {
 
 	// Print version info and warnings if either the device- or keyFlag is missing
 	glog.Infoln("Smarty Reader " + VERSION)
-	if *flags.Device == "" {
+
+	// Function defined in cmd/util/CommonKeySetup.go
+	// The key is only logged redacted
+	key, source, err := KeySetup(*flags.Key, flags.KeyFrom)
+	if err != nil {
+		glog.Exitln(err)
+	}
+	*flags.Key = key
+	if key != "" && source == "command line" {
+		glog.Warningln("Decryption key passed on the command line.\n\t" +
+			"It is visible to other users in the process list, prefer -keyFile or -keyEnv.")
+	}
+	if key != "" {
+		glog.Infof("Decryption key %s read from %s\n", smarty.RedactKey(key), source)
+	}
+	if *flags.Device == "" && *flags.Replay.File == "" {
 		glog.Warningln("Serial device parameter missing.\n\t" +
 			"This program instance will not be able to access any serial devices.")
 	}
-	if *flags.Key == "" {
+	if *flags.Key == "" && *flags.Mode != "plaintext" {
 		glog.Warningln("No decryption key found.\n\t" +
-			"Telegrams can not be decrypted in this program instance.")
+			"Encrypted telegrams can not be decrypted in this program instance.")
 	}
 	if *flags.Mqtt.Broker == "" {
 		glog.Warningln("No MQTT Broker address found.\n\t" +
@@ -0,0 +1,60 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+	CommonKeySetup holds the common decryption key loading in one file to avoid code duplicates.
+*/
+
+package util
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+// Struct holding startup key source values
+type KeyInfo struct {
+	File       *string
+	Env        *string
+	Credential *string
+	Stdin      *bool
+}
+
+// Returns the decryption key of the first source given, the -key flag is only used without any other source
+// The source describes where the key was read from, eg. for logging.
+func KeySetup(flagKey string, info KeyInfo) (key, source string, err error) {
+	switch {
+	case *info.File != "":
+		key, err = smarty.ReadKeyFile(*info.File)
+		source = "file " + *info.File
+	case *info.Credential != "":
+		key, err = smarty.ReadKeyCredential(*info.Credential)
+		source = "systemd credential " + *info.Credential
+	case *info.Env != "":
+		key, err = smarty.ReadKeyEnv(*info.Env)
+		source = "environment variable " + *info.Env
+	case *info.Stdin:
+		fmt.Fprintln(os.Stderr, "Decryption key:")
+		key, err = smarty.ReadKey(os.Stdin)
+		source = "stdin"
+	default:
+		return flagKey, "command line", nil
+	}
+	return key, source, err
+}
@@ -22,12 +22,17 @@
 package util
 
 import (
+    "errors"
     "fmt"
+    "io/ioutil"
     "math/rand"
     "os"
+    "strings"
+    "time"
 
     "github.com/NEXXTLAB/go-smarty-reader/share"
     "github.com/eclipse/paho.mqtt.golang"
+    "github.com/golang/glog"
 )
 
 // Struct holding startup mqtt values
@@ -35,6 +40,25 @@ type MqttInfo struct {
     Broker    *string
     TopicRoot *string
     Qos       *int
+    // Format of the published values: text, json or telegram
+    Payload *string
+    // Number of messages kept while the broker is unreachable
+    QueueLimit *int
+    // Authentication and TLS for private brokers
+    ClientID     *string
+    Username     *string
+    Password     *string
+    PasswordFile *string
+    CAFile       *string
+    CertFile     *string
+    KeyFile      *string
+    Insecure     *bool
+    // Meter availability: time between two telegrams and number of missed intervals until the meter is stale
+    MeterInterval  *time.Duration
+    StaleIntervals *int
+    // Home Assistant discovery
+    Discovery       *bool
+    DiscoveryPrefix *string
 }
 
 func GetHostname() string {
@@ -58,7 +82,7 @@ func randomString(n int) string {
     return string(str)
 }
 
-func MqttSetup(hostname string, info MqttInfo) share.MqttConnection {
+func MqttSetup(hostname string, info MqttInfo) (share.MqttConnection, error) {
     // The topic root serves as a common root for all published messages.
     // In order to avoid interference of other users who might publish to the same topic
     // the hostname is part of the topic root.
@@ -68,9 +92,50 @@ func MqttSetup(hostname string, info MqttInfo) share.MqttConnection {
     // Further settings can be found on the "paho.mqtt.golang" project.
     // Using the encrypted Broker "ssl://iot.eclipse.org:8883" as default using the startup flags.
     // Alternatively you could connect to "iot.eclipse.org:1883" for an unencrypted connection.
+    // Both are public brokers, use the flags below to connect to a private broker instead.
     opts := mqtt.NewClientOptions()
     opts.AddBroker(*info.Broker)
+    if *info.ClientID != "" {
+        opts.SetClientID(*info.ClientID)
+    }
+
+    // Username and password, the password file keeps the password out of the process list
+    password := *info.Password
+    if *info.PasswordFile != "" {
+        content, err := ioutil.ReadFile(*info.PasswordFile)
+        if err != nil {
+            return share.MqttConnection{}, err
+        }
+        password = strings.TrimSpace(string(content))
+    }
+    switch {
+    case *info.Username != "":
+        opts.SetUsername(*info.Username)
+        opts.SetPassword(password)
+    case password != "":
+        // MQTT does not allow a password without user name
+        return share.MqttConnection{}, errors.New("MQTT password given without -mqttUsername")
+    }
+
+    // CA bundle and client certificate, eg. for a Mosquitto requiring mutual TLS
+    tlsConfig, err := share.NewTLSConfig(share.TLSSettings{
+        CAFile:             *info.CAFile,
+        CertFile:           *info.CertFile,
+        KeyFile:            *info.KeyFile,
+        InsecureSkipVerify: *info.Insecure,
+    })
+    if err != nil {
+        return share.MqttConnection{}, err
+    }
+    if *info.Insecure {
+        glog.Warningln("The certificate of the MQTT broker is not verified.\n\t" +
+            "Only use -mqttInsecure for lab brokers.")
+    }
+    opts.SetTLSConfig(tlsConfig)
 
     // Create the client on which publishing operations can be executed
-    return share.NewMqttConnection(topicRoot, *info.Qos, opts)
+    // Messages are queued while the connection is re-established in the background
+    connection := share.NewMqttConnection(topicRoot, *info.Qos, opts)
+    connection.SetQueueLimit(*info.QueueLimit)
+    return connection, nil
 }
@@ -0,0 +1,52 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+	CommonSecuritySetup holds the common DLMS security settings in one file to avoid code duplicates.
+*/
+
+package util
+
+import (
+	"fmt"
+
+	"github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+// Struct holding startup security values
+type SecurityInfo struct {
+	AuthenticationKey *string
+	TagLength         *int
+	SecurityControl   *uint
+}
+
+// Converts the security flags into the settings used by the smarty readers
+// The security control byte has to be 0x10, 0x20, 0x30 or 0 to accept any, larger values are not truncated.
+func SecuritySetup(info SecurityInfo) (smarty.SecurityConfig, error) {
+	switch *info.SecurityControl {
+	case 0, uint(smarty.SecurityAuthentication), uint(smarty.SecurityEncryption),
+		uint(smarty.SecurityAuthenticatedEncryption):
+	default:
+		return smarty.SecurityConfig{}, fmt.Errorf("%w: security control byte 0x%X, expected 0x10, 0x20, 0x30 or 0",
+			smarty.ErrInvalidSecurityConfig, *info.SecurityControl)
+	}
+	return smarty.SecurityConfig{
+		AuthenticationKey: *info.AuthenticationKey,
+		TagLength:         *info.TagLength,
+		SecurityControl:   byte(*info.SecurityControl),
+	}, nil
+}
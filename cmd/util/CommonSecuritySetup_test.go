@@ -0,0 +1,42 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package util
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+// Test if only the supported security control bytes are passed on, without truncating larger values
+func TestSecuritySetup(t *testing.T) {
+	authenticationKey, tagLength := "00112233445566778899AABBCCDDEEFF", 12
+	for securityControl, valid := range map[uint]bool{0: true, 0x10: true, 0x20: true, 0x30: true, 0x31: false,
+		0x40: false, 0x130: false} {
+		securityControl := securityControl
+		config, err := SecuritySetup(SecurityInfo{AuthenticationKey: &authenticationKey, TagLength: &tagLength,
+			SecurityControl: &securityControl})
+		switch {
+		case valid && (err != nil || uint(config.SecurityControl) != securityControl):
+			t.Errorf("Security control byte 0x%X: unexpected config %+v (%v)", securityControl, config, err)
+		case !valid && !errors.Is(err, smarty.ErrInvalidSecurityConfig):
+			t.Errorf("Security control byte 0x%X: expected ErrInvalidSecurityConfig, got %v", securityControl, err)
+		}
+	}
+}
@@ -0,0 +1,126 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+	CommonSerialSetup holds the common serial line operations in one file to avoid code duplicates.
+*/
+
+package util
+
+import (
+	"os"
+	"time"
+
+	"github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+// Struct holding startup serial line values
+type SerialInfo struct {
+	Baud        *int
+	Format      *string
+	ReadTimeout *time.Duration
+	AutoProbe   *bool
+	NonInverted *bool
+}
+
+// Converts the serial line flags into the settings used by the smarty readers
+func SerialSetup(device string, info SerialInfo) (smarty.SerialConfig, error) {
+	config := smarty.DefaultSerialConfig(device)
+	dataBits, parity, stopBits, err := smarty.ParseSerialFormat(*info.Format)
+	if err != nil {
+		return smarty.SerialConfig{}, err
+	}
+	config.Baud = *info.Baud
+	config.DataBits = dataBits
+	config.Parity = parity
+	config.StopBits = stopBits
+	config.ReadTimeout = *info.ReadTimeout
+	config.AutoProbe = *info.AutoProbe
+	config.NonInvertedSignal = *info.NonInverted
+	return config, nil
+}
+
+// Struct holding startup replay values
+type ReplayInfo struct {
+	File  *string
+	Speed *float64
+}
+
+// Opens the capture to replay, nil if no capture has been passed
+func ReplaySetup(info ReplayInfo) (*smarty.ReplayReader, error) {
+	if *info.File == "" {
+		return nil, nil
+	}
+	file, err := os.Open(*info.File)
+	if err != nil {
+		return nil, err
+	}
+	replay, err := smarty.NewReplayReader(file, *info.Speed)
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	return replay, nil
+}
+
+// Opens the OnlineDecryptor matching the flags, reading from the serial device or replaying a capture
+// Unencrypted DSMR meters can be read without a key, in plaintext and auto mode. Without key, encrypted telegrams
+// are reported with ErrInvalidKey once they arrive.
+func OnlineDecryptorSetup(flags Flag) (*smarty.OnlineDecryptor, error) {
+	mode, err := smarty.ParseReaderMode(*flags.Mode)
+	if err != nil {
+		return nil, err
+	}
+	// The serial settings are validated before the replay is opened, which would otherwise stay open
+	config, err := SerialSetup(*flags.Device, flags.Serial)
+	if err != nil {
+		return nil, err
+	}
+	replay, err := ReplaySetup(flags.Replay)
+	if err != nil {
+		return nil, err
+	}
+
+	var smartyObj *smarty.OnlineDecryptor
+	switch {
+	case mode != smarty.ModeEncrypted && *flags.Key == "" && replay != nil:
+		smartyObj = smarty.NewPlainTextReaderFromReader(replay)
+	case mode != smarty.ModeEncrypted && *flags.Key == "":
+		smartyObj, err = smarty.NewPlainTextReader(config)
+	case replay != nil:
+		smartyObj, err = smarty.NewOnlineDecryptorFromReader(replay, *flags.Key)
+	default:
+		smartyObj, err = smarty.NewOnlineDecryptorWithConfig(config, *flags.Key)
+	}
+	if err != nil {
+		if replay != nil {
+			replay.Close()
+		}
+		return nil, err
+	}
+	// Function defined in cmd/util/CommonSecuritySetup.go
+	securityConfig, err := SecuritySetup(flags.Security)
+	if err == nil {
+		err = smartyObj.SetSecurityConfig(securityConfig)
+	}
+	if err != nil {
+		smartyObj.Disconnect()
+		return nil, err
+	}
+	smartyObj.SetMode(mode)
+	return smartyObj, nil
+}
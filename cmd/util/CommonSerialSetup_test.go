@@ -0,0 +1,115 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package util
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+var dsmrTelegram = []byte("/ISK5\\2M550T-1012\r\n\r\n1-0:1.8.1(000404.680*kWh)\r\n!6796\r\n")
+
+// Returns the flags of a replay without key
+func replayFlags(mode, file string) Flag {
+	key, format, device := "", "8N1", ""
+	baud, tagLength := 115200, 12
+	readTimeout, speed := 500*time.Millisecond, 0.0
+	autoProbe, nonInverted := false, false
+	authenticationKey, securityControl := "00112233445566778899AABBCCDDEEFF", uint(0x30)
+	return Flag{
+		Device: &device,
+		Key:    &key,
+		Mode:   &mode,
+		Serial: SerialInfo{Baud: &baud, Format: &format, ReadTimeout: &readTimeout, AutoProbe: &autoProbe,
+			NonInverted: &nonInverted},
+		Replay: ReplayInfo{File: &file, Speed: &speed},
+		Security: SecurityInfo{AuthenticationKey: &authenticationKey, TagLength: &tagLength,
+			SecurityControl: &securityControl},
+	}
+}
+
+// Test if auto mode reads unencrypted meters without key, and reports encrypted telegrams once they arrive
+func TestOnlineDecryptorSetupWithoutKey(t *testing.T) {
+	encoder, err := smarty.NewEncoder("000102030405060708090A0B0C0D0E0F", []byte("SAGgp\x00\x00\x01"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encrypted, err := encoder.Encode(dsmrTelegram, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	directory, err := ioutil.TempDir("", "replay")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+
+	smartyObj, err := OnlineDecryptorSetup(replayFlags("auto", writeCapture(t, directory, dsmrTelegram, dsmrTelegram)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer smartyObj.Disconnect()
+	for i := 1; i <= 2; i++ {
+		if plainText, err := smartyObj.GetTelegram(); err != nil || !bytes.Equal(plainText, dsmrTelegram) {
+			t.Errorf("Telegram %d: unexpected telegram: %v \n%s\n", i, err, plainText)
+		}
+	}
+
+	file := writeCapture(t, directory, encrypted)
+	smartyObj, err = OnlineDecryptorSetup(replayFlags("auto", file))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer smartyObj.Disconnect()
+	if _, err = smartyObj.GetTelegram(); !errors.Is(err, smarty.ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey for an encrypted telegram, got %v", err)
+	}
+
+	if _, err = OnlineDecryptorSetup(replayFlags("encrypted", file)); !errors.Is(err, smarty.ErrInvalidKey) {
+		t.Errorf("Expected ErrInvalidKey in encrypted mode without key, got %v", err)
+	}
+}
+
+// Writes the chunks into a new capture file within the directory
+func writeCapture(t *testing.T, directory string, chunks ...[]byte) (file string) {
+	var capture bytes.Buffer
+	writer, err := smarty.NewCaptureWriter(&capture)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, chunk := range chunks {
+		if err = writer.WriteChunk(chunk, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+	}
+	output, err := ioutil.TempFile(directory, "*.p1cap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer output.Close()
+	if _, err = output.Write(capture.Bytes()); err != nil {
+		t.Fatal(err)
+	}
+	return output.Name()
+}
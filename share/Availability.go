@@ -0,0 +1,151 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   Availability topics, retained so that new subscribers know the state right away:
+       <topicRoot>status:       "online" once connected, "offline" on disconnect or as Last Will if the reader dies
+       <topicRoot>meter/status: "online" while telegrams arrive, "stale" if none arrived within several intervals
+   A reader which is online with a stale meter points to the meter or the P1 cable, rather than the reader.
+*/
+
+package share
+
+import (
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Availability topics and payloads
+const (
+	StatusTopic      = "status"
+	MeterStatusTopic = "meter/status"
+
+	StatusOnline  = "online"
+	StatusOffline = "offline"
+	StatusStale   = "stale"
+)
+
+// Struct reporting the meter as stale if no valid telegram arrived within a number of telegram intervals
+type MeterAvailability struct {
+	connection MqttConnection
+	timeout    time.Duration
+	// Clock, replaced by the tests
+	now      func() time.Time
+	mutex    sync.Mutex
+	lastSeen time.Time
+	status   string
+	// Signals the watcher to publish the changed status, publishing never delays Seen
+	changed   chan struct{}
+	published string
+	stop      chan struct{}
+	stopOnce  sync.Once
+}
+
+// Creation of a new MeterAvailability, the meter is reported stale if no telegram arrives within the timeout
+// Parameter:
+// * connection: the connection to publish the meter status over
+// * interval: the time between two telegrams, 10 seconds for the smarty
+// * intervals: the number of intervals without valid telegram after which the meter is stale
+// Return:
+// * MeterAvailability: a new object to execute methods on, call Stop once done
+func NewMeterAvailability(connection MqttConnection, interval time.Duration, intervals int) *MeterAvailability {
+	if intervals < 1 {
+		intervals = 1
+	}
+	ma := newMeterAvailability(connection, interval*time.Duration(intervals), time.Now)
+	go ma.watch(interval)
+	return ma
+}
+
+func newMeterAvailability(connection MqttConnection, timeout time.Duration, now func() time.Time) *MeterAvailability {
+	return &MeterAvailability{
+		connection: connection,
+		timeout:    timeout,
+		now:        now,
+		lastSeen:   now(),
+		changed:    make(chan struct{}, 1),
+		stop:       make(chan struct{}),
+	}
+}
+
+// Reports a valid telegram, the meter is online again if it was stale
+// The status is published in the background, a slow broker does not delay the caller.
+func (ma *MeterAvailability) Seen() {
+	ma.mutex.Lock()
+	defer ma.mutex.Unlock()
+	ma.lastSeen = ma.now()
+	ma.setStatus(StatusOnline)
+}
+
+// Stops watching the meter, its status is kept
+func (ma *MeterAvailability) Stop() {
+	ma.stopOnce.Do(func() {
+		close(ma.stop)
+	})
+}
+
+func (ma *MeterAvailability) watch(interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			ma.check()
+		case <-ma.changed:
+			ma.publish()
+		case <-ma.stop:
+			return
+		}
+	}
+}
+
+// Marks the meter stale if the last telegram is older than the timeout
+func (ma *MeterAvailability) check() {
+	ma.mutex.Lock()
+	defer ma.mutex.Unlock()
+	if ma.now().Sub(ma.lastSeen) > ma.timeout && ma.status != StatusStale {
+		glog.Warningf("No valid telegram within %s, meter is stale\n", ma.timeout)
+		ma.setStatus(StatusStale)
+	}
+}
+
+// Changes the status, the caller holds the lock
+func (ma *MeterAvailability) setStatus(status string) {
+	if ma.status == status {
+		return
+	}
+	ma.status = status
+	select {
+	case ma.changed <- struct{}{}:
+	default:
+		// The watcher has not yet published the previous change, it publishes the latest status
+	}
+}
+
+// Publishes the latest status if it differs from the published one, only called by the watcher
+func (ma *MeterAvailability) publish() {
+	ma.mutex.Lock()
+	status := ma.status
+	ma.mutex.Unlock()
+	if status == ma.published {
+		return
+	}
+	ma.published = status
+	ma.connection.publishTo(ma.connection.Topic(MeterStatusTopic), []byte(status), true)
+}
@@ -0,0 +1,72 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package share
+
+import (
+	"testing"
+	"time"
+)
+
+// Test if the meter is reported stale without telegrams, and online again once a telegram arrives
+func TestMeterAvailability(t *testing.T) {
+	client := &fakeClient{connected: true}
+	c := MqttConnection{client: client, settings: Settings{topicRoot: "smarty/"}, state: newConnectionState()}
+
+	// The watcher is driven explicitly, using a clock of its own
+	now := time.Date(2018, 1, 30, 10, 21, 22, 0, time.UTC)
+	availability := newMeterAvailability(c, 20*time.Second, func() time.Time { return now })
+	now = now.Add(15 * time.Second)
+	availability.check()
+	availability.publish()
+	now = now.Add(10 * time.Second)
+	availability.check()
+	availability.check()
+	availability.publish()
+	availability.Seen()
+	availability.Seen()
+	availability.publish()
+	availability.publish()
+
+	client.mutex.Lock()
+	defer client.mutex.Unlock()
+	if len(client.published) != 2 || client.published[0] != StatusStale || client.published[1] != StatusOnline {
+		t.Errorf("Meter status published as %v, expected [stale online]", client.published)
+	}
+	for _, topic := range client.topics {
+		if topic != "smarty/meter/status" {
+			t.Errorf("Meter status published to %s", topic)
+		}
+	}
+}
+
+// Test if the reader announces itself online once connected, before the queued messages
+func TestBirthMessage(t *testing.T) {
+	client := &fakeClient{}
+	c := MqttConnection{client: client, settings: Settings{topicRoot: "smarty/"}, state: newConnectionState()}
+	c.send("smarty/value", "1", false)
+
+	client.mutex.Lock()
+	client.connected = true
+	client.mutex.Unlock()
+	c.onConnect()
+
+	if len(client.published) != 2 || client.topics[0] != "smarty/status" || client.published[0] != StatusOnline ||
+		client.published[1] != "1" {
+		t.Errorf("Published %v to %v, expected the online status first", client.published, client.topics)
+	}
+}
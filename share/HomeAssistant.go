@@ -0,0 +1,280 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   Home Assistant MQTT discovery: the DiscoveryPublisher announces every published OBIS code as sensor, using
+   retained messages on <prefix>/sensor/<meter>/<obis>/config. Home Assistant then creates the sensors of the meter,
+   including device and state classes, so the energy readings show up on the Energy dashboard without setup.
+   See https://www.home-assistant.io/integrations/sensor.mqtt/
+*/
+
+package share
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+
+	"github.com/NEXXTLAB/go-smarty-reader/smarty"
+	"github.com/NEXXTLAB/go-smarty-reader/smarty/obis"
+	"github.com/golang/glog"
+)
+
+// Home Assistant device and state classes
+const (
+	DeviceClassEnergy        = "energy"
+	DeviceClassPower         = "power"
+	DeviceClassReactivePower = "reactive_power"
+	DeviceClassApparentPower = "apparent_power"
+	DeviceClassVoltage       = "voltage"
+	DeviceClassCurrent       = "current"
+
+	StateClassMeasurement     = "measurement"
+	StateClassTotalIncreasing = "total_increasing"
+)
+
+// The published values carry their unit (see Publish), Home Assistant expects the number only
+const valueTemplate = "{{ value.split(' ')[0] | float }}"
+
+// Struct holding the discovery message of a sensor, marshalled to JSON
+type SensorConfig struct {
+	Name              string       `json:"name"`
+	UniqueID          string       `json:"unique_id"`
+	StateTopic        string       `json:"state_topic"`
+	ValueTemplate     string       `json:"value_template"`
+	UnitOfMeasurement string       `json:"unit_of_measurement,omitempty"`
+	DeviceClass       string       `json:"device_class,omitempty"`
+	StateClass        string       `json:"state_class,omitempty"`
+	Device            SensorDevice `json:"device"`
+	// The sensor is available only if all topics report available, see Availability.go
+	Availability     []SensorAvailability `json:"availability,omitempty"`
+	AvailabilityMode string               `json:"availability_mode,omitempty"`
+}
+
+// Struct holding a topic reporting the availability of a sensor
+type SensorAvailability struct {
+	Topic               string `json:"topic"`
+	PayloadAvailable    string `json:"payload_available"`
+	PayloadNotAvailable string `json:"payload_not_available"`
+}
+
+// Struct holding the meter the sensors belong to
+type SensorDevice struct {
+	Identifiers     []string `json:"identifiers"`
+	Name            string   `json:"name"`
+	Manufacturer    string   `json:"manufacturer,omitempty"`
+	Model           string   `json:"model,omitempty"`
+	SerialNumber    string   `json:"serial_number,omitempty"`
+	SoftwareVersion string   `json:"sw_version,omitempty"`
+}
+
+type sensorDescription struct {
+	name, deviceClass, stateClass string
+}
+
+// Sensors of the OBIS codes of the smarty, other numeric codes are described by their unit
+var sensorDescriptions = map[string]sensorDescription{
+	obis.EnergyImport:         {"Energy import", DeviceClassEnergy, StateClassTotalIncreasing},
+	obis.EnergyExport:         {"Energy export", DeviceClassEnergy, StateClassTotalIncreasing},
+	obis.ReactiveEnergyImport: {"Reactive energy import", "", StateClassTotalIncreasing},
+	obis.ReactiveEnergyExport: {"Reactive energy export", "", StateClassTotalIncreasing},
+	obis.PowerImport:          {"Power import", DeviceClassPower, StateClassMeasurement},
+	obis.PowerExport:          {"Power export", DeviceClassPower, StateClassMeasurement},
+	obis.ReactivePowerImport:  {"Reactive power import", DeviceClassReactivePower, StateClassMeasurement},
+	obis.ReactivePowerExport:  {"Reactive power export", DeviceClassReactivePower, StateClassMeasurement},
+	obis.PowerImportL1:        {"Power import L1", DeviceClassPower, StateClassMeasurement},
+	obis.PowerImportL2:        {"Power import L2", DeviceClassPower, StateClassMeasurement},
+	obis.PowerImportL3:        {"Power import L3", DeviceClassPower, StateClassMeasurement},
+	obis.PowerExportL1:        {"Power export L1", DeviceClassPower, StateClassMeasurement},
+	obis.PowerExportL2:        {"Power export L2", DeviceClassPower, StateClassMeasurement},
+	obis.PowerExportL3:        {"Power export L3", DeviceClassPower, StateClassMeasurement},
+	obis.VoltageL1:            {"Voltage L1", DeviceClassVoltage, StateClassMeasurement},
+	obis.VoltageL2:            {"Voltage L2", DeviceClassVoltage, StateClassMeasurement},
+	obis.VoltageL3:            {"Voltage L3", DeviceClassVoltage, StateClassMeasurement},
+	obis.CurrentL1:            {"Current L1", DeviceClassCurrent, StateClassMeasurement},
+	obis.CurrentL2:            {"Current L2", DeviceClassCurrent, StateClassMeasurement},
+	obis.CurrentL3:            {"Current L3", DeviceClassCurrent, StateClassMeasurement},
+	obis.ActiveThreshold:      {"Active threshold", DeviceClassApparentPower, StateClassMeasurement},
+	obis.BreakerState:         {"Breaker state", "", ""},
+	obis.PowerFailures:        {"Power failures", "", StateClassTotalIncreasing},
+}
+
+// Creates the discovery message of an OBIS code
+// Parameter:
+// * reading: the parsed telegram, its equipment ID identifies the meter
+// * object: the OBIS code to announce
+// * stateTopic: the topic the value of the OBIS code is published to, see MqttConnection.Topic
+// Return:
+// * config: the discovery message
+// * ok: false if the object is not numeric or the meter has no equipment ID
+func NewSensorConfig(reading obis.Reading, object obis.Object, stateTopic string) (config SensorConfig, ok bool) {
+	if !object.Numeric || reading.EquipmentID == "" {
+		return SensorConfig{}, false
+	}
+	description, known := sensorDescriptions[object.ID]
+	if !known {
+		description = describeUnit(object)
+	}
+	if object.Unit == "" {
+		// Home Assistant rejects device classes without unit, eg. the reactive power of the smarty
+		description.deviceClass = ""
+	}
+	meter := topicID(reading.EquipmentID)
+	config = SensorConfig{
+		Name:              description.name,
+		UniqueID:          meter + "_" + topicID(object.ID),
+		StateTopic:        stateTopic,
+		ValueTemplate:     valueTemplate,
+		UnitOfMeasurement: object.Unit,
+		DeviceClass:       description.deviceClass,
+		StateClass:        description.stateClass,
+		Device: SensorDevice{
+			Identifiers:     []string{meter},
+			Name:            "Meter " + reading.EquipmentID,
+			Model:           reading.Header,
+			SerialNumber:    reading.EquipmentID,
+			SoftwareVersion: reading.Version,
+		},
+	}
+	if len(reading.EquipmentID) >= 3 {
+		config.Device.Manufacturer = smarty.ManufacturerName(reading.EquipmentID[:3])
+	}
+	return config, true
+}
+
+// Derives the classes of OBIS codes unknown to the smarty from their unit
+func describeUnit(object obis.Object) sensorDescription {
+	description := sensorDescription{name: object.ID, stateClass: StateClassMeasurement}
+	switch object.Unit {
+	case "Wh", "kWh", "MWh":
+		description.deviceClass, description.stateClass = DeviceClassEnergy, StateClassTotalIncreasing
+	case "W", "kW":
+		description.deviceClass = DeviceClassPower
+	case "var", "kvar":
+		description.deviceClass = DeviceClassReactivePower
+	case "VA", "kVA":
+		description.deviceClass = DeviceClassApparentPower
+	case "V":
+		description.deviceClass = DeviceClassVoltage
+	case "A":
+		description.deviceClass = DeviceClassCurrent
+	}
+	return description
+}
+
+// Home Assistant only accepts letters, digits, '_' and '-' in the discovery topic and unique ID
+func topicID(id string) string {
+	return strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' {
+			return r
+		}
+		return '_'
+	}, id)
+}
+
+// Struct publishing the discovery messages of all OBIS codes published over a MqttConnection
+type DiscoveryPublisher struct {
+	connection MqttConnection
+	prefix     string
+	mode       PayloadMode
+	// True if the meter status is published by a MeterAvailability
+	meterAvailability bool
+	mutex             sync.Mutex
+	// OBIS codes already announced, by meter
+	announced map[string]bool
+}
+
+// Creation of a new DiscoveryPublisher
+// Parameter:
+// * connection: the connection the OBIS codes are published over
+// * prefix: the discovery prefix configured in Home Assistant, "homeassistant" by default
+// Return:
+// * DiscoveryPublisher: a new object to execute methods on
+func NewDiscoveryPublisher(connection MqttConnection, prefix string) *DiscoveryPublisher {
+	return &DiscoveryPublisher{
+		connection: connection,
+		prefix:     strings.TrimSuffix(prefix, "/"),
+		announced:  make(map[string]bool),
+	}
+}
+
+// Sets the format the OBIS codes are published in, the sensors extract their value accordingly
+// Parameter:
+// * mode: PayloadText (default), PayloadJSON or PayloadTelegram, see PublishReading
+func (dp *DiscoveryPublisher) SetPayloadMode(mode PayloadMode) {
+	dp.mutex.Lock()
+	defer dp.mutex.Unlock()
+	dp.mode = mode
+}
+
+// Makes the sensors unavailable while the meter is stale, in addition to while the reader is offline
+// Parameter:
+// * enabled: true if the meter status is published by a MeterAvailability
+func (dp *DiscoveryPublisher) SetMeterAvailability(enabled bool) {
+	dp.mutex.Lock()
+	defer dp.mutex.Unlock()
+	dp.meterAvailability = enabled
+}
+
+// Publishes the discovery messages of all numeric OBIS codes of a telegram not yet announced
+// Call it before publishing the values, every OBIS code is announced once.
+// Parameter:
+// * reading: the parsed telegram
+// Return:
+// * announced: the number of published discovery messages
+func (dp *DiscoveryPublisher) Announce(reading obis.Reading) (announced int) {
+	if reading.EquipmentID == "" {
+		glog.Warningln("Telegram without equipment ID, unable to announce its sensors")
+		return 0
+	}
+	dp.mutex.Lock()
+	defer dp.mutex.Unlock()
+	for _, object := range reading.Objects {
+		key := reading.EquipmentID + " " + object.ID
+		if dp.announced[key] {
+			continue
+		}
+		config, ok := NewSensorConfig(reading, object, dp.connection.Topic(object.ID))
+		if !ok {
+			continue
+		}
+		config.Availability = []SensorAvailability{{dp.connection.Topic(StatusTopic), StatusOnline, StatusOffline}}
+		if dp.meterAvailability {
+			config.Availability = append(config.Availability,
+				SensorAvailability{dp.connection.Topic(MeterStatusTopic), StatusOnline, StatusStale})
+			config.AvailabilityMode = "all"
+		}
+		switch dp.mode {
+		case PayloadJSON:
+			config.ValueTemplate = "{{ value_json.value }}"
+		case PayloadTelegram:
+			config.StateTopic = dp.connection.Topic(TelegramTopic)
+			config.ValueTemplate = "{{ value_json.objects['" + object.ID + "'].value }}"
+		}
+		payload, err := json.Marshal(config)
+		if err != nil {
+			glog.Errorln(err)
+			continue
+		}
+		topic := dp.prefix + "/sensor/" + topicID(reading.EquipmentID) + "/" + topicID(object.ID) + "/config"
+		if dp.connection.publishTo(topic, payload, true) {
+			dp.announced[key] = true
+			announced++
+		}
+	}
+	return announced
+}
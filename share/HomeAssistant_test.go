@@ -0,0 +1,78 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package share_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/NEXXTLAB/go-smarty-reader/share"
+	"github.com/NEXXTLAB/go-smarty-reader/smarty/obis"
+)
+
+// Test if the OBIS codes of the smarty are announced with the classes required by the Energy dashboard
+func TestSensorConfig(t *testing.T) {
+	reading, err := obis.ParseWithMode([]byte(strings.Join([]string{
+		"/Lux5\\253663629_D",
+		"",
+		"1-3:0.2.8(42)",
+		"0-0:42.0.0(53414731303330373030313134303034)",
+		"1-0:1.8.0(000006.695*kWh)",
+		"1-0:1.7.0(00.312*kW)",
+		"1-0:3.7.0(00.000)",
+		"1-0:32.7.0(231.0*V)",
+		"1-0:31.7.0(001*A)",
+		"0-0:96.13.0()",
+		"!",
+	}, "\r\n")), obis.ChecksumLenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string][3]string{
+		obis.EnergyImport:        {"kWh", share.DeviceClassEnergy, share.StateClassTotalIncreasing},
+		obis.PowerImport:         {"kW", share.DeviceClassPower, share.StateClassMeasurement},
+		obis.ReactivePowerImport: {"", "", share.StateClassMeasurement},
+		obis.VoltageL1:           {"V", share.DeviceClassVoltage, share.StateClassMeasurement},
+		obis.CurrentL1:           {"A", share.DeviceClassCurrent, share.StateClassMeasurement},
+	}
+	for _, object := range reading.Objects {
+		config, ok := share.NewSensorConfig(reading, object, "smarty/"+object.ID)
+		classes, announced := expected[object.ID]
+		if ok != announced {
+			t.Errorf("OBIS code %s announced: %v", object.ID, ok)
+			continue
+		}
+		if !ok {
+			continue
+		}
+		if config.UnitOfMeasurement != classes[0] || config.DeviceClass != classes[1] ||
+			config.StateClass != classes[2] {
+			t.Errorf("OBIS code %s announced as %q, %q, %q", object.ID, config.UnitOfMeasurement,
+				config.DeviceClass, config.StateClass)
+		}
+		if config.Device.Manufacturer != "Sagemcom" || config.Device.SerialNumber != "SAG1030700114004" ||
+			!strings.HasPrefix(config.UniqueID, "SAG1030700114004_") || strings.ContainsAny(config.UniqueID, ":.") {
+			t.Errorf("Unexpected device %+v or unique ID %q", config.Device, config.UniqueID)
+		}
+		if _, err := json.Marshal(config); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   Payload formats for publishing the OBIS codes. Besides the formatted text of Publish ("6.695 kWh"), the values
+   can be published as JSON, either one document per OBIS code:
+       <topicRoot><OBIS code>: {"value":6.695,"unit":"kWh","ts":"2018-01-30T10:21:22+01:00"}
+   or one document per telegram:
+       <topicRoot>telegram: {"timestamp":"2018-01-30T10:21:22+01:00","equipment_id":"SAG1030700114004",
+           "frame_counter":370915,"objects":{"1-0:1.8.0":{"value":6.695,"unit":"kWh"},...}}
+   Timestamps are those of the meter, in RFC 3339 format. Objects without numeric value are not included.
+*/
+
+package share
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/NEXXTLAB/go-smarty-reader/smarty/obis"
+	"github.com/golang/glog"
+)
+
+// Format of the published OBIS codes
+type PayloadMode int
+
+const (
+	// Formatted text per OBIS code, eg. "6.695 kWh", see Publish
+	PayloadText PayloadMode = iota
+	// JSON document per OBIS code, see ValuePayload
+	PayloadJSON
+	// JSON document per telegram on a single topic, see TelegramPayload
+	PayloadTelegram
+)
+
+// Topic extension of the PayloadTelegram documents
+const TelegramTopic = "telegram"
+
+func (pm PayloadMode) String() string {
+	switch pm {
+	case PayloadText:
+		return "text"
+	case PayloadJSON:
+		return "json"
+	case PayloadTelegram:
+		return "telegram"
+	}
+	return fmt.Sprintf("PayloadMode(%d)", int(pm))
+}
+
+// Parses the name of a payload mode
+// Parameter:
+// * name: "text", "json" or "telegram"
+// Return:
+// * mode: the payload mode
+// * err: error if the name is unknown
+func ParsePayloadMode(name string) (mode PayloadMode, err error) {
+	for _, mode = range []PayloadMode{PayloadText, PayloadJSON, PayloadTelegram} {
+		if name == mode.String() {
+			return mode, nil
+		}
+	}
+	return PayloadText, fmt.Errorf("unknown payload mode %q, expected text, json or telegram", name)
+}
+
+// Struct holding a single OBIS code, published in PayloadJSON mode
+type ValuePayload struct {
+	Value     float64   `json:"value"`
+	Unit      string    `json:"unit,omitempty"`
+	Timestamp time.Time `json:"ts"`
+}
+
+// Struct holding a whole telegram, published in PayloadTelegram mode
+type TelegramPayload struct {
+	Timestamp    time.Time                `json:"timestamp"`
+	EquipmentID  string                   `json:"equipment_id"`
+	FrameCounter uint32                   `json:"frame_counter,omitempty"`
+	Objects      map[string]ObjectPayload `json:"objects"`
+}
+
+// Struct holding an OBIS code of a TelegramPayload
+type ObjectPayload struct {
+	Value float64 `json:"value"`
+	Unit  string  `json:"unit,omitempty"`
+}
+
+// Creates the document of an OBIS code
+// Parameter:
+// * reading: the parsed telegram, providing the timestamp
+// * object: the OBIS code
+// Return:
+// * payload: the document
+// * ok: false if the object has no numeric value
+func NewValuePayload(reading obis.Reading, object obis.Object) (payload ValuePayload, ok bool) {
+	if !object.Numeric {
+		return ValuePayload{}, false
+	}
+	return ValuePayload{Value: object.Value, Unit: object.Unit, Timestamp: reading.Timestamp}, true
+}
+
+// Creates the document of a telegram
+// Parameter:
+// * reading: the parsed telegram
+// * frameCounter: the frame counter of the encrypted telegram, 0 for unencrypted telegrams
+// Return:
+// * payload: the document
+func NewTelegramPayload(reading obis.Reading, frameCounter uint32) (payload TelegramPayload) {
+	payload = TelegramPayload{
+		Timestamp:    reading.Timestamp,
+		EquipmentID:  reading.EquipmentID,
+		FrameCounter: frameCounter,
+		Objects:      make(map[string]ObjectPayload, len(reading.Objects)),
+	}
+	for _, object := range reading.Objects {
+		if object.Numeric {
+			payload.Objects[object.ID] = ObjectPayload{Value: object.Value, Unit: object.Unit}
+		}
+	}
+	return payload
+}
+
+// Publishes a document as JSON to the MQTT broker
+// Parameter:
+// * extension: the topic suffix (topicRoot + extension), eg. an OBIS code or TelegramTopic
+// * payload: the document, eg. a ValuePayload or TelegramPayload
+// * retained: set to true if the message should be retained by the MQTT server
+// Return:
+// * published: true if the message was published or queued until the broker is reachable
+func (c MqttConnection) PublishJSON(extension string, payload interface{}, retained bool) (published bool) {
+	message, err := json.Marshal(payload)
+	if err != nil {
+		glog.Errorf("Unable to publish to %s: %s\n", extension, err)
+		return false
+	}
+	return c.publishTo(c.Topic(extension), message, retained)
+}
+
+// Publishes all numeric OBIS codes of a telegram in the given mode
+// Parameter:
+// * reading: the parsed telegram
+// * frameCounter: the frame counter of the encrypted telegram, only published in PayloadTelegram mode
+// * mode: the payload format
+// * retained: set to true if the messages should be retained by the MQTT server
+// * updateOnlyIfChanged: only used in PayloadText mode, see Publish
+// Return:
+// * published: the number of published or queued messages
+func (c MqttConnection) PublishReading(reading obis.Reading, frameCounter uint32, mode PayloadMode, retained,
+	updateOnlyIfChanged bool) (published int) {
+	switch mode {
+	case PayloadTelegram:
+		if c.PublishJSON(TelegramTopic, NewTelegramPayload(reading, frameCounter), retained) {
+			published++
+		}
+	case PayloadJSON:
+		for _, object := range reading.Objects {
+			if payload, ok := NewValuePayload(reading, object); ok && c.PublishJSON(object.ID, payload, retained) {
+				published++
+			}
+		}
+	default:
+		for _, object := range reading.Objects {
+			if c.Publish(object.ID, object.RawValue, object.Unit, retained, updateOnlyIfChanged) {
+				published++
+			}
+		}
+	}
+	return published
+}
@@ -0,0 +1,76 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package share_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/NEXXTLAB/go-smarty-reader/share"
+	"github.com/NEXXTLAB/go-smarty-reader/smarty/obis"
+)
+
+// Test if the values of a telegram are converted into numbers with separate units
+func TestPayload(t *testing.T) {
+	reading, err := obis.ParseWithMode([]byte(strings.Join([]string{
+		"/Lux5\\253663629_D",
+		"",
+		"0-0:1.0.0(180130102122W)",
+		"0-0:42.0.0(53414731303330373030313134303034)",
+		"1-0:1.8.0(000006.695*kWh)",
+		"1-0:3.7.0(00.000)",
+		"0-0:96.13.0()",
+		"!",
+	}, "\r\n")), obis.ChecksumLenient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	document, err := json.Marshal(share.NewTelegramPayload(reading, 370915))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"timestamp":"2018-01-30T10:21:22+01:00","equipment_id":"SAG1030700114004","frame_counter":370915,` +
+		`"objects":{"1-0:1.8.0":{"value":6.695,"unit":"kWh"},"1-0:3.7.0":{"value":0}}}`
+	if string(document) != expected {
+		t.Errorf("Telegram published as %s", document)
+	}
+
+	energy, _ := reading.Object(obis.EnergyImport)
+	payload, ok := share.NewValuePayload(reading, energy)
+	if document, err = json.Marshal(payload); err != nil || !ok {
+		t.Fatal(err)
+	}
+	if string(document) != `{"value":6.695,"unit":"kWh","ts":"2018-01-30T10:21:22+01:00"}` {
+		t.Errorf("OBIS code published as %s", document)
+	}
+	message, _ := reading.Object("0-0:96.13.0")
+	if _, ok = share.NewValuePayload(reading, message); ok {
+		t.Error("Object without numeric value published")
+	}
+
+	for _, mode := range []share.PayloadMode{share.PayloadText, share.PayloadJSON, share.PayloadTelegram} {
+		if parsed, err := share.ParsePayloadMode(mode.String()); err != nil || parsed != mode {
+			t.Errorf("Payload mode %s parsed as %s: %v", mode, parsed, err)
+		}
+	}
+	if _, err = share.ParsePayloadMode("xml"); err == nil {
+		t.Error("Unknown payload mode accepted")
+	}
+}
@@ -0,0 +1,263 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   Keeps the MQTT connection alive on unreliable networks: lost connections are re-established in the background
+   with exponential backoff, and messages which could not be published are queued in memory. The queue is drained
+   in order once the broker is reachable again, if it is full the oldest messages are dropped.
+*/
+
+package share
+
+import (
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/golang/glog"
+)
+
+const (
+	// Default number of messages kept while the broker is unreachable
+	DefaultQueueLimit = 1000
+	// Maximum time to wait for the broker to acknowledge a message
+	publishTimeout = 10 * time.Second
+	// Delays between two connection attempts
+	minReconnectDelay = time.Second
+	maxReconnectDelay = 2 * time.Minute
+)
+
+var errPublishTimeout = errors.New("no acknowledgement from the MQTT broker")
+
+// Struct holding the counters of the message queue
+type QueueStats struct {
+	// Messages waiting for the broker
+	Queued int
+	// Messages published after waiting in the queue
+	Delayed uint64
+	// Messages dropped since the queue was full
+	Dropped uint64
+}
+
+type queuedMessage struct {
+	id       uint64
+	topic    string
+	payload  interface{}
+	retained bool
+}
+
+// Struct holding the state shared by all copies of a MqttConnection
+type connectionState struct {
+	mutex         sync.Mutex
+	queue         []queuedMessage
+	queueLimit    int
+	nextID        uint64
+	stats         QueueStats
+	draining      bool
+	reconnecting  bool
+	closed        bool
+	subscriptions map[string]mqtt.MessageHandler
+}
+
+func newConnectionState() *connectionState {
+	return &connectionState{
+		queueLimit:    DefaultQueueLimit,
+		subscriptions: make(map[string]mqtt.MessageHandler),
+	}
+}
+
+// Sets the number of messages kept while the broker is unreachable
+// Parameter:
+// * limit: the maximum number of queued messages, 0 to drop messages which can not be published right away
+func (c MqttConnection) SetQueueLimit(limit int) {
+	c.state.mutex.Lock()
+	defer c.state.mutex.Unlock()
+	c.state.queueLimit = limit
+}
+
+// Returns the counters of the message queue
+// Return:
+// * stats: the number of queued, delayed and dropped messages
+func (c MqttConnection) QueueStats() (stats QueueStats) {
+	c.state.mutex.Lock()
+	defer c.state.mutex.Unlock()
+	stats = c.state.stats
+	stats.Queued = len(c.state.queue)
+	return stats
+}
+
+// Publishes a message, or queues it if the broker is unreachable or earlier messages are still queued
+// Return:
+// * published: true if the message was published right away
+// * queued: true if the message was queued instead
+func (c MqttConnection) send(topic string, payload interface{}, retained bool) (published, queued bool) {
+	c.state.mutex.Lock()
+	waiting := len(c.state.queue) > 0
+	c.state.mutex.Unlock()
+
+	// Earlier messages are published first
+	if !waiting && c.client.IsConnected() {
+		err := c.publishNow(topic, payload, retained)
+		if err == nil {
+			return true, false
+		}
+		glog.Warningf("Unable to publish to %s, queueing: %s\n", topic, err)
+	}
+	queued = c.enqueue(queuedMessage{topic: topic, payload: payload, retained: retained})
+	if c.client.IsConnected() {
+		go c.drain()
+	}
+	return false, queued
+}
+
+func (c MqttConnection) publishNow(topic string, payload interface{}, retained bool) error {
+	token := c.client.Publish(topic, byte(c.settings.qos), retained, payload)
+	if !token.WaitTimeout(publishTimeout) {
+		return errPublishTimeout
+	}
+	return token.Error()
+}
+
+func (c MqttConnection) enqueue(message queuedMessage) (accepted bool) {
+	c.state.mutex.Lock()
+	defer c.state.mutex.Unlock()
+	if c.state.queueLimit <= 0 {
+		c.state.stats.Dropped++
+		return false
+	}
+	if len(c.state.queue) >= c.state.queueLimit {
+		// The oldest message is the least relevant one
+		c.state.queue = c.state.queue[1:]
+		c.state.stats.Dropped++
+		if c.state.stats.Dropped%100 == 1 {
+			glog.Warningf("MQTT queue full, dropping the oldest messages (%d dropped in total)\n",
+				c.state.stats.Dropped)
+		}
+	}
+	c.state.nextID++
+	message.id = c.state.nextID
+	c.state.queue = append(c.state.queue, message)
+	return true
+}
+
+// Publishes the queued messages in order, stops at the first failure keeping the message
+// Only a single drain runs at a time.
+func (c MqttConnection) drain() {
+	c.state.mutex.Lock()
+	if c.state.draining {
+		c.state.mutex.Unlock()
+		return
+	}
+	c.state.draining = true
+	c.state.mutex.Unlock()
+
+	defer func() {
+		c.state.mutex.Lock()
+		c.state.draining = false
+		c.state.mutex.Unlock()
+	}()
+	published := 0
+	for {
+		c.state.mutex.Lock()
+		if len(c.state.queue) == 0 {
+			c.state.mutex.Unlock()
+			break
+		}
+		message := c.state.queue[0]
+		c.state.mutex.Unlock()
+
+		if err := c.publishNow(message.topic, message.payload, message.retained); err != nil {
+			glog.Warningf("Unable to publish queued message to %s: %s\n", message.topic, err)
+			break
+		}
+		c.state.mutex.Lock()
+		// The message may have been dropped from the full queue in the meantime
+		if len(c.state.queue) > 0 && c.state.queue[0].id == message.id {
+			c.state.queue = c.state.queue[1:]
+		}
+		c.state.stats.Delayed++
+		c.state.mutex.Unlock()
+		published++
+	}
+	if published > 0 {
+		glog.Infof("Published %d delayed MQTT messages\n", published)
+	}
+}
+
+// Connects in the background until the broker is reachable, waiting longer after every failed attempt
+// Only a single reconnect loop runs at a time.
+func (c MqttConnection) reconnectInBackground() {
+	c.state.mutex.Lock()
+	if c.state.reconnecting || c.state.closed {
+		c.state.mutex.Unlock()
+		return
+	}
+	c.state.reconnecting = true
+	c.state.mutex.Unlock()
+
+	go func() {
+		defer func() {
+			c.state.mutex.Lock()
+			c.state.reconnecting = false
+			c.state.mutex.Unlock()
+		}()
+		delay := minReconnectDelay
+		for {
+			time.Sleep(delay)
+			c.state.mutex.Lock()
+			closed := c.state.closed
+			c.state.mutex.Unlock()
+			if closed || c.client.IsConnected() {
+				return
+			}
+			err := c.connect()
+			if err == nil {
+				return
+			}
+			if delay *= 2; delay > maxReconnectDelay {
+				delay = maxReconnectDelay
+			}
+			glog.Warningf("MQTT connection failed, retrying in %s: %s\n", delay, err)
+		}
+	}()
+}
+
+// Called by the client once connected, restores the subscriptions and publishes the queued messages
+func (c MqttConnection) onConnect() {
+	glog.Infoln("MQTT Client connected")
+	// The birth message replaces the retained Last Will
+	if err := c.publishNow(c.Topic(StatusTopic), StatusOnline, true); err != nil {
+		glog.Errorf("Unable to publish the online status: %s\n", err)
+	}
+	c.state.mutex.Lock()
+	subscriptions := make(map[string]mqtt.MessageHandler, len(c.state.subscriptions))
+	for topic, callback := range c.state.subscriptions {
+		subscriptions[topic] = callback
+	}
+	c.state.mutex.Unlock()
+
+	for topic, callback := range subscriptions {
+		token := c.client.Subscribe(topic, byte(c.settings.qos), callback)
+		if token.WaitTimeout(publishTimeout) && token.Error() == nil {
+			glog.Infof("Restored subscription to topic: %s\n", topic)
+		} else {
+			glog.Errorf("Unable to restore subscription to topic: %s\n", topic)
+		}
+	}
+	c.drain()
+}
@@ -0,0 +1,98 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package share
+
+import (
+	"errors"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang"
+)
+
+// Client recording the published messages, publishing fails while disconnected
+type fakeClient struct {
+	mqtt.Client
+	mutex     sync.Mutex
+	connected bool
+	published []string
+	// Topics of the published messages
+	topics []string
+}
+
+type fakeToken struct {
+	err error
+}
+
+func (ft fakeToken) Wait() bool                     { return true }
+func (ft fakeToken) WaitTimeout(time.Duration) bool { return true }
+func (ft fakeToken) Error() error                   { return ft.err }
+
+func (fc *fakeClient) IsConnected() bool {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	return fc.connected
+}
+
+func (fc *fakeClient) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	fc.mutex.Lock()
+	defer fc.mutex.Unlock()
+	if !fc.connected {
+		return fakeToken{errors.New("not connected")}
+	}
+	fc.topics = append(fc.topics, topic)
+	switch payload := payload.(type) {
+	case string:
+		fc.published = append(fc.published, payload)
+	case []byte:
+		fc.published = append(fc.published, string(payload))
+	}
+	return fakeToken{}
+}
+
+// Test if messages are queued while disconnected, dropping the oldest, and drained in order after reconnecting
+func TestQueue(t *testing.T) {
+	client := &fakeClient{}
+	c := MqttConnection{client: client, settings: Settings{topicRoot: "smarty/"}, state: newConnectionState()}
+	c.SetQueueLimit(2)
+
+	for _, value := range []string{"1", "2", "3"} {
+		if _, queued := c.send("smarty/value", value, false); !queued {
+			t.Errorf("Message %s not queued", value)
+		}
+	}
+	if stats := c.QueueStats(); stats.Queued != 2 || stats.Dropped != 1 || len(client.published) != 0 {
+		t.Errorf("Unexpected queue %+v while disconnected, published %v", stats, client.published)
+	}
+
+	client.mutex.Lock()
+	client.connected = true
+	client.mutex.Unlock()
+	c.drain()
+	if published, _ := c.send("smarty/value", "4", false); !published {
+		t.Error("Message 4 not published")
+	}
+	if stats := c.QueueStats(); stats.Queued != 0 || stats.Delayed != 2 || stats.Dropped != 1 {
+		t.Errorf("Unexpected queue %+v after reconnecting", stats)
+	}
+	if len(client.published) != 3 || client.published[0] != "2" || client.published[1] != "3" ||
+		client.published[2] != "4" {
+		t.Errorf("Messages published as %v, expected [2 3 4]", client.published)
+	}
+}
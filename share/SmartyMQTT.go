@@ -35,6 +35,8 @@ import (
 type MqttConnection struct {
 	client   mqtt.Client
 	settings Settings
+	// Queue and reconnect state, shared by all copies of the connection
+	state *connectionState
 }
 
 // Struct holding user settings for the connection
@@ -45,41 +47,63 @@ type Settings struct {
 }
 
 // Creating a new MQTT connection
+// If the broker is unreachable, connecting is retried in the background while messages are queued. Lost
+// connections are re-established the same way, see Queue.go.
 // Parameter:
 // * topicRoot: common prefix of a MQTT topic for this connection
 // * qualityOfService: the MQTT quality of service for all operations
-// * options:
+// * options: the client options, their connect and connection lost handlers and Last Will are replaced
 // Return:
-// * c: MqttConnection struct, containing a connected MQTT client and the specified parameters
+// * c: MqttConnection struct, containing a MQTT client and the specified parameters
 func NewMqttConnection(topicRoot string, qualityOfService int, options *mqtt.ClientOptions) (c MqttConnection) {
 	lastCharacter := topicRoot[len(topicRoot)-1:]
 	if lastCharacter != "/" {
 		topicRoot = topicRoot + "/"
 	}
 	c = MqttConnection{
-		client: mqtt.NewClient(options),
 		settings: Settings{
 			topicRoot: topicRoot,
 			qos:       qualityOfService,
 			opts:      options,
 		},
+		state: newConnectionState(),
 	}
+	// The broker announces the connection offline if it is lost, see Availability.go
+	options.SetWill(c.Topic(StatusTopic), StatusOffline, byte(qualityOfService), true)
+	// Reconnecting is handled by the connection, to apply the backoff and drain the queue
+	options.SetAutoReconnect(false)
+	options.SetOnConnectHandler(func(mqtt.Client) {
+		c.onConnect()
+	})
+	options.SetConnectionLostHandler(func(_ mqtt.Client, err error) {
+		glog.Errorf("MQTT connection lost: %s\n", err)
+		c.reconnectInBackground()
+	})
+	c.client = mqtt.NewClient(options)
 	c.Reconnect()
 	return c
 }
 
 // (Re)Connects the MQTT client
+// If the broker is unreachable, connecting is retried in the background with exponential backoff.
 func (c MqttConnection) Reconnect() {
+	c.state.mutex.Lock()
+	c.state.closed = false
+	c.state.mutex.Unlock()
 	if !c.client.IsConnected() {
-		token := c.client.Connect()
-		if token.Wait() && token.Error() != nil {
-			glog.Fatalln(token.Error())
-		} else {
-			glog.Infoln("MQTT Client connected")
+		if err := c.connect(); err != nil {
+			glog.Errorf("MQTT connection failed, retrying in the background: %s\n", err)
+			c.reconnectInBackground()
 		}
 	}
 }
 
+func (c MqttConnection) connect() error {
+	token := c.client.Connect()
+	token.Wait()
+	return token.Error()
+}
+
 // Publishes a message to the MQTT broker
 // Parameter:
 // * obis: the obis code, which will be used as topic suffix
@@ -89,7 +113,8 @@ func (c MqttConnection) Reconnect() {
 // * updateOnlyIfChanged: set to true to publish the message only if the value differs from the previous
 //      This avoids short interval subscriber updates without any value change
 // Return:
-// * updated: true if a message was published (depending on updateOnlyIfChanged!)
+// * updated: true if a message was published or queued until the broker is reachable (depending on
+//      updateOnlyIfChanged!)
 func (c MqttConnection) Publish(obis, value, unit string, retained, updateOnlyIfChanged bool) (updated bool) {
 	formattedInput := formatValue(value)
 	if unit != "" {
@@ -97,19 +122,57 @@ func (c MqttConnection) Publish(obis, value, unit string, retained, updateOnlyIf
 	}
 	// Implication of updateOnlyIfChanged => isNewValueFor
 	if !updateOnlyIfChanged || isNewValueFor(obis, formattedInput) {
-		tokenP := c.client.Publish(c.settings.topicRoot+obis,
-			byte(c.settings.qos), retained, formattedInput)
-		if tokenP.Wait() && tokenP.Error() == nil {
+		published, queued := c.send(c.settings.topicRoot+obis, formattedInput, retained)
+		if published || queued {
 			updateValueFor(obis, formattedInput)
+		}
+		switch {
+		case published:
 			glog.Infof("Successfully published %s for OBIS %s\n", formattedInput, obis)
-		} else {
+		case queued:
+			glog.Infof("Queued %s for OBIS %s until the broker is reachable\n", formattedInput, obis)
+		default:
 			glog.Errorf("Unable to publish %s for OBIS: %s\n", formattedInput, obis)
 		}
-		return tokenP.Error() == nil
+		return published || queued
 	}
 	return false
 }
 
+// Publishes a binary message to the MQTT broker, eg. an encrypted telegram
+// Parameter:
+// * extension: the topic suffix (topicRoot + extension)
+// * payload: the MQTT message, published unmodified
+// * retained: set to true if the message should be retained by the MQTT server
+// Return:
+// * published: true if the message was published or queued until the broker is reachable
+func (c MqttConnection) PublishBinary(extension string, payload []byte, retained bool) (published bool) {
+	return c.publishTo(c.Topic(extension), payload, retained)
+}
+
+// Returns the full topic of an extension, as used by Publish and Subscribe
+// Parameter:
+// * extension: the topic suffix, eg. an OBIS code
+// Return:
+// * topic: topicRoot + extension
+func (c MqttConnection) Topic(extension string) (topic string) {
+	return c.settings.topicRoot + extension
+}
+
+// Publishes a message to a topic outside of the topic root
+func (c MqttConnection) publishTo(topic string, payload []byte, retained bool) (published bool) {
+	published, queued := c.send(topic, payload, retained)
+	switch {
+	case published:
+		glog.Infof("Successfully published %d bytes to %s\n", len(payload), topic)
+	case queued:
+		glog.Infof("Queued %d bytes for %s until the broker is reachable\n", len(payload), topic)
+	default:
+		glog.Errorf("Unable to publish %d bytes to %s\n", len(payload), topic)
+	}
+	return published || queued
+}
+
 // Registers as subscriber to the specified topic
 // Parameter:
 // * obis: the topic extension to subscribe (topicRoot + extension)
@@ -117,7 +180,20 @@ func (c MqttConnection) Publish(obis, value, unit string, retained, updateOnlyIf
 // Return:
 // success: true is subscribing the topic was successful
 func (c MqttConnection) Subscribe(obis string, callback mqtt.MessageHandler) (success bool) {
-	topic := c.settings.topicRoot + obis
+	return c.SubscribeTopic(c.settings.topicRoot+obis, callback)
+}
+
+// Registers as subscriber to a topic outside of the topic root, eg. a wildcard topic covering several readers
+// Parameter:
+// * topic: the full topic to subscribe, wildcards are allowed
+// * callback: the callback function to call once a new message arrives (specify your own)
+// Return:
+// success: true is subscribing the topic was successful
+func (c MqttConnection) SubscribeTopic(topic string, callback mqtt.MessageHandler) (success bool) {
+	// Restored after reconnecting
+	c.state.mutex.Lock()
+	c.state.subscriptions[topic] = callback
+	c.state.mutex.Unlock()
 	tokenP := c.client.Subscribe(topic, byte(c.settings.qos), callback)
 	if tokenP.Wait() && tokenP.Error() == nil {
 		glog.Errorf("Successfully subscribed to topic: %s\n", topic)
@@ -154,6 +230,18 @@ func updateValueFor(obis string, formattedInput string) {
 // Parameter:
 // * quiesce: amount of milliseconds to wait before closing
 func (c MqttConnection) Disconnect(quiesce uint) {
+	c.state.mutex.Lock()
+	c.state.closed = true
+	if len(c.state.queue) > 0 {
+		glog.Warningf("%d queued MQTT messages discarded\n", len(c.state.queue))
+	}
+	c.state.mutex.Unlock()
+	// The Last Will is only sent if the connection is lost
+	if c.client.IsConnected() {
+		if err := c.publishNow(c.Topic(StatusTopic), StatusOffline, true); err != nil {
+			glog.Errorf("Unable to publish the offline status: %s\n", err)
+		}
+	}
 	c.client.Disconnect(quiesce)
 	glog.Infoln("MQTT connection closed")
 }
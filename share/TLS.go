@@ -0,0 +1,71 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   TLS settings for private MQTT brokers, eg. a Mosquitto requiring client certificates (mutual TLS). The broker
+   address must use the ssl:// or tls:// scheme for the settings to apply.
+*/
+
+package share
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// Struct holding the TLS settings of the connection to the broker
+type TLSSettings struct {
+	// PEM file holding the certificate authorities to trust, the system pool is used if empty
+	CAFile string
+	// PEM files holding the client certificate and its private key, for brokers requiring client certificates
+	CertFile, KeyFile string
+	// Accepts any broker certificate, only meant for lab brokers with self-signed certificates
+	InsecureSkipVerify bool
+}
+
+// Creates the TLS configuration to pass to the MQTT client options (SetTLSConfig)
+// Parameter:
+// * settings: the certificate files and verification setting
+// Return:
+// * config: the TLS configuration
+// * err: error if a file can not be read or does not hold a valid certificate or key
+func NewTLSConfig(settings TLSSettings) (config *tls.Config, err error) {
+	config = &tls.Config{InsecureSkipVerify: settings.InsecureSkipVerify}
+	if settings.CAFile != "" {
+		bundle, err := ioutil.ReadFile(settings.CAFile)
+		if err != nil {
+			return nil, err
+		}
+		config.RootCAs = x509.NewCertPool()
+		if !config.RootCAs.AppendCertsFromPEM(bundle) {
+			return nil, fmt.Errorf("no PEM certificate found in CA bundle %s", settings.CAFile)
+		}
+	}
+	if settings.CertFile != "" || settings.KeyFile != "" {
+		if settings.CertFile == "" || settings.KeyFile == "" {
+			return nil, fmt.Errorf("client certificate and key are both required")
+		}
+		certificate, err := tls.LoadX509KeyPair(settings.CertFile, settings.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("invalid client certificate: %s", err)
+		}
+		config.Certificates = []tls.Certificate{certificate}
+	}
+	return config, nil
+}
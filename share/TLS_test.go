@@ -0,0 +1,99 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package share_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/NEXXTLAB/go-smarty-reader/share"
+)
+
+// Test if the CA bundle and client certificate are loaded, and incomplete settings rejected
+func TestTLSConfig(t *testing.T) {
+	directory, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(directory)
+	certFile, keyFile := writeCertificate(t, directory)
+
+	config, err := share.NewTLSConfig(share.TLSSettings{CAFile: certFile, CertFile: certFile, KeyFile: keyFile})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.RootCAs == nil || len(config.Certificates) != 1 || config.InsecureSkipVerify {
+		t.Errorf("Unexpected TLS configuration %+v", config)
+	}
+
+	for _, invalid := range []share.TLSSettings{
+		{CAFile: keyFile},
+		{CAFile: filepath.Join(directory, "missing.pem")},
+		{CertFile: certFile},
+		{CertFile: keyFile, KeyFile: certFile},
+	} {
+		if _, err = share.NewTLSConfig(invalid); err == nil {
+			t.Errorf("Invalid settings %+v accepted", invalid)
+		}
+	}
+}
+
+// Writes a self-signed certificate and its key as PEM files
+func writeCertificate(t *testing.T, directory string) (certFile, keyFile string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "smarty"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	certificate, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	privateKey, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile = filepath.Join(directory, "client.pem"), filepath.Join(directory, "client.key")
+	if err = ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate}),
+		0600); err != nil {
+		t.Fatal(err)
+	}
+	if err = ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: privateKey}),
+		0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
@@ -0,0 +1,288 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   Captures hold the raw byte stream of a P1 port with the time each chunk has been received, for bug reports,
+   regression tests and offline decryption. The container format is:
+
+       header: "P1CAP" (5 bytes), version 0x01 (1 byte), start time (8 bytes, big endian, Unix time in nanoseconds)
+       chunk:  time since the previous chunk (uvarint, microseconds), length (uvarint), data (length bytes)
+
+   The first chunk refers to the start time. Chunks follow each other until the end of the file, the replay of a
+   capture interrupted within a chunk ends after the last complete chunk.
+*/
+package smarty
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	captureMagic   = "P1CAP"
+	captureVersion = 1
+)
+
+// Reports whether the input starts with the signature of a capture file, without consuming it
+// Parameter:
+// * input: the buffered input, eg. an archive of unknown format
+// Return:
+// * capture: true if the input can be passed to NewCaptureReader or NewReplayReader
+func IsCapture(input *bufio.Reader) (capture bool) {
+	start, _ := input.Peek(len(captureMagic))
+	return string(start) == captureMagic
+}
+
+// Struct writing a capture file
+type CaptureWriter struct {
+	writer io.Writer
+	last   time.Time
+	closed bool
+	mutex  sync.Mutex
+}
+
+// Creation of a new CaptureWriter, the header is written right away
+// Parameter:
+// * output: the file to write the capture to, closed on Close if possible
+// Return:
+// * CaptureWriter: a new object to execute methods on, pass it to SetCapture of a reader
+// * err: error if the header could not be written
+func NewCaptureWriter(output io.Writer) (*CaptureWriter, error) {
+	start := time.Now()
+	header := make([]byte, 0, len(captureMagic)+9)
+	header = append(header, captureMagic...)
+	header = append(header, captureVersion)
+	header = appendUint64(header, uint64(start.UnixNano()))
+	if _, err := output.Write(header); err != nil {
+		return nil, err
+	}
+	return &CaptureWriter{writer: output, last: start}, nil
+}
+
+// Appends a chunk of received bytes to the capture
+// Parameter:
+// * data: the bytes as read from the device
+// * receivedAt: the time the bytes have been read
+// Return:
+// * err: ErrClosed once the capture has been closed, otherwise error if the chunk could not be written
+func (cw *CaptureWriter) WriteChunk(data []byte, receivedAt time.Time) error {
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+	if cw.closed {
+		return ErrClosed
+	}
+
+	offset := receivedAt.Sub(cw.last)
+	if offset < 0 {
+		offset = 0
+	}
+	chunk := make([]byte, 0, 2*binary.MaxVarintLen64+len(data))
+	chunk = appendUvarint(chunk, uint64(offset/time.Microsecond))
+	chunk = appendUvarint(chunk, uint64(len(data)))
+	chunk = append(chunk, data...)
+	// Written at once, a capture which is read while being recorded only misses the chunk being written
+	if _, err := cw.writer.Write(chunk); err != nil {
+		return err
+	}
+	// Keep the rounding of the offset, so that the offsets add up to the receive times
+	cw.last = cw.last.Add(offset / time.Microsecond * time.Microsecond)
+	return nil
+}
+
+// Stops the capture, waiting for a chunk being written. Further chunks are rejected with ErrClosed.
+// Return:
+// * err: the error of closing the output, if it can be closed
+func (cw *CaptureWriter) Close() error {
+	cw.mutex.Lock()
+	defer cw.mutex.Unlock()
+	if cw.closed {
+		return nil
+	}
+	cw.closed = true
+	if closer, ok := cw.writer.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+// Struct reading the chunks of a capture file
+type CaptureReader struct {
+	reader *bufio.Reader
+	last   time.Time
+}
+
+// Creation of a new CaptureReader, the header is read right away
+// Parameter:
+// * input: the capture file
+// Return:
+// * CaptureReader: a new object to execute methods on
+// * err: ErrCaptureFormat if the input is not a capture file
+func NewCaptureReader(input io.Reader) (*CaptureReader, error) {
+	reader := bufio.NewReader(input)
+	header := make([]byte, len(captureMagic)+9)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, fmt.Errorf("%w: header: %v", ErrCaptureFormat, err)
+	}
+	if string(header[:len(captureMagic)]) != captureMagic {
+		return nil, fmt.Errorf("%w: missing %s signature", ErrCaptureFormat, captureMagic)
+	}
+	if version := header[len(captureMagic)]; version != captureVersion {
+		return nil, fmt.Errorf("%w: unsupported version %d", ErrCaptureFormat, version)
+	}
+	start := int64(binary.BigEndian.Uint64(header[len(captureMagic)+1:]))
+	return &CaptureReader{reader: reader, last: time.Unix(0, start)}, nil
+}
+
+// Reads the next chunk of the capture
+// Return:
+// * data: the bytes as read from the device
+// * receivedAt: the time the bytes have been read
+// * err: io.EOF at the end of the capture, io.ErrUnexpectedEOF if it ends within a chunk,
+//      ErrCaptureFormat if the chunk is malformed
+func (cr *CaptureReader) ReadChunk() (data []byte, receivedAt time.Time, err error) {
+	offset, err := binary.ReadUvarint(cr.reader)
+	if err != nil {
+		return nil, time.Time{}, captureError("chunk time", err)
+	}
+	length, err := binary.ReadUvarint(cr.reader)
+	if err != nil {
+		return nil, time.Time{}, captureError("chunk length", err)
+	}
+	if length > maxCaptureChunk {
+		return nil, time.Time{}, fmt.Errorf("%w: chunk of %d bytes", ErrCaptureFormat, length)
+	}
+	data = make([]byte, length)
+	if _, err = io.ReadFull(cr.reader, data); err != nil {
+		return nil, time.Time{}, captureError("chunk data", err)
+	}
+	cr.last = cr.last.Add(time.Duration(offset) * time.Microsecond)
+	return data, cr.last, nil
+}
+
+func captureError(field string, err error) error {
+	switch err {
+	case io.EOF:
+		// Only the end of the capture between two chunks is regular
+		if field == "chunk time" {
+			return io.EOF
+		}
+		return io.ErrUnexpectedEOF
+	case io.ErrUnexpectedEOF:
+		return err
+	}
+	return fmt.Errorf("%w: %s: %v", ErrCaptureFormat, field, err)
+}
+
+// Upper limit of a chunk, a single read of the serial port returns far less
+const maxCaptureChunk = 1 << 20
+
+// Struct replaying a capture as byte stream, keeping the time between the chunks
+// It can be passed to NewOnlineDecryptorFromReader or NewCipherForwarderFromReader in place of the serial port.
+type ReplayReader struct {
+	capture  *CaptureReader
+	closer   io.Closer
+	speed    float64
+	pending  []byte
+	previous time.Time
+	closed   chan struct{}
+	once     sync.Once
+}
+
+// Creation of a new ReplayReader
+// Parameter:
+// * input: the capture file, closed on Close if possible
+// * speed: 1 for real time, 10 to replay ten times faster, 0 to replay without waiting
+// Return:
+// * ReplayReader: a new object to read from
+// * err: ErrCaptureFormat if the input is not a capture file
+func NewReplayReader(input io.Reader, speed float64) (*ReplayReader, error) {
+	capture, err := NewCaptureReader(input)
+	if err != nil {
+		return nil, err
+	}
+	closer, _ := input.(io.Closer)
+	return &ReplayReader{
+		capture:  capture,
+		closer:   closer,
+		speed:    speed,
+		previous: capture.last,
+		closed:   make(chan struct{}),
+	}, nil
+}
+
+// Returns the bytes of the capture, waiting between the chunks according to the replay speed
+// Return:
+// * n: number of bytes read
+// * err: io.EOF at the end of the capture, ErrClosed once closed, ErrCaptureFormat if the capture is malformed
+func (rr *ReplayReader) Read(p []byte) (n int, err error) {
+	if len(rr.pending) == 0 {
+		data, receivedAt, err := rr.capture.ReadChunk()
+		if err == io.ErrUnexpectedEOF {
+			// The recording has been interrupted within the last chunk
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		if rr.speed > 0 {
+			timer := time.NewTimer(time.Duration(float64(receivedAt.Sub(rr.previous)) / rr.speed))
+			select {
+			case <-timer.C:
+			case <-rr.closed:
+				timer.Stop()
+				return 0, ErrClosed
+			}
+		}
+		rr.previous = receivedAt
+		rr.pending = data
+	}
+	select {
+	case <-rr.closed:
+		return 0, ErrClosed
+	default:
+	}
+	n = copy(p, rr.pending)
+	rr.pending = rr.pending[n:]
+	return n, nil
+}
+
+// Stops the replay, a waiting Read returns ErrClosed
+func (rr *ReplayReader) Close() error {
+	var err error
+	rr.once.Do(func() {
+		close(rr.closed)
+		if rr.closer != nil {
+			err = rr.closer.Close()
+		}
+	})
+	return err
+}
+
+func appendUint64(b []byte, v uint64) []byte {
+	var buffer [8]byte
+	binary.BigEndian.PutUint64(buffer[:], v)
+	return append(b, buffer[:]...)
+}
+
+func appendUvarint(b []byte, v uint64) []byte {
+	var buffer [binary.MaxVarintLen64]byte
+	return append(b, buffer[:binary.PutUvarint(buffer[:], v)]...)
+}
@@ -0,0 +1,124 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package smarty_test
+
+import (
+    "bufio"
+    "bytes"
+    "errors"
+    "io"
+    "testing"
+    "time"
+
+    "github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+// Test if the chunks and their receive times survive the capture file
+func TestCaptureChunks(t *testing.T) {
+    var file bytes.Buffer
+    writer, err := smarty.NewCaptureWriter(&file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    start := time.Now()
+    times := []time.Time{start.Add(time.Second), start.Add(1500 * time.Millisecond), start.Add(10 * time.Second)}
+    for i, receivedAt := range times {
+        if err = writer.WriteChunk(telegram[i*100:(i+1)*100], receivedAt); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    if !smarty.IsCapture(bufio.NewReader(bytes.NewReader(file.Bytes()))) ||
+        smarty.IsCapture(bufio.NewReader(bytes.NewReader(telegram[:]))) {
+        t.Error("Capture signature not detected")
+    }
+
+    reader, err := smarty.NewCaptureReader(&file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i, expected := range times {
+        data, receivedAt, err := reader.ReadChunk()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !bytes.Equal(data, telegram[i*100:(i+1)*100]) {
+            t.Errorf("Chunk %d: unexpected data", i+1)
+        }
+        if difference := receivedAt.Sub(expected); difference < -time.Microsecond || difference > time.Microsecond {
+            t.Errorf("Chunk %d: received at %v, expected %v", i+1, receivedAt, expected)
+        }
+    }
+    if _, _, err = reader.ReadChunk(); err != io.EOF {
+        t.Errorf("Expected io.EOF at the end of the capture, got %v", err)
+    }
+
+    if _, err = smarty.NewCaptureReader(bytes.NewReader(telegram[:])); !errors.Is(err, smarty.ErrCaptureFormat) {
+        t.Errorf("Expected ErrCaptureFormat for a telegram, got %v", err)
+    }
+
+    // Chunks read after closing the capture are rejected instead of written into the closed file
+    length := file.Len()
+    if err = writer.Close(); err != nil {
+        t.Fatal(err)
+    }
+    if err = writer.WriteChunk(telegram[:100], time.Now()); err != smarty.ErrClosed || file.Len() != length {
+        t.Errorf("Expected ErrClosed for a chunk after Close, got %v", err)
+    }
+}
+
+// Test if a capture recorded while decrypting replays into the same telegram, at accelerated speed
+func TestCaptureReplay(t *testing.T) {
+    var file bytes.Buffer
+    writer, err := smarty.NewCaptureWriter(&file)
+    if err != nil {
+        t.Fatal(err)
+    }
+    recording, err := smarty.NewOnlineDecryptorFromReader(bytes.NewReader(telegram[:]), key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    recording.SetCapture(writer)
+    expected, err := recording.GetTelegram()
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    // Cut the capture within the last chunk, as if the recording had been killed
+    captured := append(file.Bytes(), 0x00, 0x10, 0x01)
+    replay, err := smarty.NewReplayReader(bytes.NewReader(captured), 1000)
+    if err != nil {
+        t.Fatal(err)
+    }
+    smartyObj, err := smarty.NewOnlineDecryptorFromReader(replay, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer smartyObj.Disconnect()
+
+    plainText, err := smartyObj.GetTelegram()
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(plainText, expected) {
+        t.Error("Replayed telegram differs from the recorded one")
+    }
+    if _, err = smartyObj.GetTelegram(); err != io.EOF {
+        t.Errorf("Expected io.EOF at the end of the replay, got %v", err)
+    }
+}
@@ -17,28 +17,37 @@
 
 /*
    This file contains two Object definitions. First the Decryptor which is able to decrypt a provided smarty
-   telegram and return the original text. Second is the OnlineDecryptor which will listen to the serial device,
-   read, split it into its components and return the plain text.
+   telegram and return the original text. Second is the OnlineDecryptor which will listen to the serial device
+   (or any other byte stream), read, split it into its components and return the plain text.
 */
 
 package smarty
 
 import (
+    "context"
     "crypto/aes"
     "crypto/cipher"
     "encoding/hex"
-
-    "github.com/golang/glog"
+    "errors"
+    "fmt"
+    "io"
+    "time"
 )
 
 // Struct allowing simple decryption of existing telegrams
+// The cipher is set up once, a Decryptor is safe for concurrent use.
 type Decryptor struct {
-    key, aad []byte
+    key      []byte
+    security security
+    block    cipher.Block
+    aead     cipher.AEAD
 }
 
 // Struct allowing live capture of smarty telegrams with decryption
 type OnlineDecryptor struct {
-    decryptor Decryptor
+    decryptor    Decryptor
+    replayGuard  *ReplayGuard
+    replayPolicy ReplayPolicy
     deviceInfo
 }
 
@@ -48,17 +57,117 @@ type OnlineDecryptor struct {
 // * decryptionKey: your smarty key
 // Return:
 // * OnlineDecryptor: a new object to execute methods on
-func NewOnlineDecryptor(deviceName, decryptionKey string) OnlineDecryptor {
-    reader, port := openSerialConnection(deviceName)
-    glog.Infoln("Serial connection established")
-    return OnlineDecryptor{
-        decryptor: NewDecryptor(decryptionKey),
-        deviceInfo: deviceInfo{
-            reader:     reader,
-            port:       port,
-            deviceName: deviceName,
-        },
+// * err: ErrInvalidKey or ErrDeviceUnavailable if the OnlineDecryptor could not be created
+func NewOnlineDecryptor(deviceName, decryptionKey string) (*OnlineDecryptor, error) {
+    return NewOnlineDecryptorWithConfig(DefaultSerialConfig(deviceName), decryptionKey)
+}
+
+// Creation of a new OnlineDecryptor with custom serial line settings
+// Parameter:
+// * config: the port to listen to and its settings, see DefaultSerialConfig
+// * decryptionKey: your smarty key
+// Return:
+// * OnlineDecryptor: a new object to execute methods on
+// * err: ErrInvalidKey or ErrDeviceUnavailable if the OnlineDecryptor could not be created
+func NewOnlineDecryptorWithConfig(config SerialConfig, decryptionKey string) (*OnlineDecryptor, error) {
+    decryptor, err := NewDecryptor(decryptionKey)
+    if err != nil {
+        return nil, err
+    }
+    deviceInfo, err := newSerialDeviceInfo(config)
+    if err != nil {
+        return nil, err
+    }
+    return newOnlineDecryptor(decryptor, deviceInfo), nil
+}
+
+// Creation of a new OnlineDecryptor reading from an arbitrary byte stream instead of a serial port
+// Parameter:
+// * input: the byte stream to read from (TCP socket, pipe, recorded file, ...), closed on Disconnect if possible
+// * decryptionKey: your smarty key
+// Return:
+// * OnlineDecryptor: a new object to execute methods on
+// * err: ErrInvalidKey if the key could not be parsed
+func NewOnlineDecryptorFromReader(input io.Reader, decryptionKey string) (*OnlineDecryptor, error) {
+    decryptor, err := NewDecryptor(decryptionKey)
+    if err != nil {
+        return nil, err
     }
+    return newOnlineDecryptor(decryptor, newStreamDeviceInfo(input)), nil
+}
+
+// Creation of a new OnlineDecryptor for unencrypted DSMR meters, which do not require a key
+// Parameter:
+// * config: the port to listen to and its settings, usually 115200 baud 8N1 (DSMR 4/5) or 9600 baud 7E1 (DSMR 2/3)
+// Return:
+// * OnlineDecryptor: a new object in ModePlainText to execute methods on
+// * err: ErrDeviceUnavailable if the serial device could not be opened
+func NewPlainTextReader(config SerialConfig) (*OnlineDecryptor, error) {
+    deviceInfo, err := newSerialDeviceInfo(config)
+    if err != nil {
+        return nil, err
+    }
+    od := newOnlineDecryptor(Decryptor{}, deviceInfo)
+    od.SetMode(ModePlainText)
+    return od, nil
+}
+
+// Creation of a new OnlineDecryptor for unencrypted DSMR telegrams read from an arbitrary byte stream
+// Parameter:
+// * input: the byte stream to read from, closed on Disconnect if possible
+// Return:
+// * OnlineDecryptor: a new object in ModePlainText to execute methods on
+func NewPlainTextReaderFromReader(input io.Reader) *OnlineDecryptor {
+    od := newOnlineDecryptor(Decryptor{}, newStreamDeviceInfo(input))
+    od.SetMode(ModePlainText)
+    return od
+}
+
+func newOnlineDecryptor(decryptor Decryptor, deviceInfo deviceInfo) *OnlineDecryptor {
+    return &OnlineDecryptor{
+        decryptor:    decryptor,
+        replayGuard:  NewReplayGuard(),
+        replayPolicy: ReplayFlag,
+        deviceInfo:   deviceInfo,
+    }
+}
+
+// Sets the behaviour for telegrams whose frame counter did not increase
+// Parameter:
+// * policy: ReplayFlag (default) to deliver them flagged, ReplayReject to drop them with ErrReplay
+func (od *OnlineDecryptor) SetReplayPolicy(policy ReplayPolicy) {
+    od.replayPolicy = policy
+}
+
+// Sets the format of the telegrams, do not call while a read is running
+// Parameter:
+// * mode: ModeEncrypted (default), ModePlainText for unencrypted DSMR meters or ModeAuto to detect the format
+func (od *OnlineDecryptor) SetMode(mode ReaderMode) {
+    od.deviceInfo.reader.SetMode(mode)
+}
+
+// Sets the security settings of meters other than the smarty
+// Parameter:
+// * config: the authentication key, tag length and expected security control byte
+// Return:
+// * err: ErrInvalidSecurityConfig if the settings are not supported, the previous settings are kept
+func (od *OnlineDecryptor) SetSecurityConfig(config SecurityConfig) error {
+    settings, err := newSecurity(config)
+    if err != nil {
+        return err
+    }
+    if od.decryptor.key != nil {
+        // The tag length is part of the cipher
+        decryptor, err := newDecryptor(od.decryptor.key, settings)
+        if err != nil {
+            return err
+        }
+        od.decryptor = decryptor
+    } else {
+        od.decryptor.security = settings
+    }
+    od.deviceInfo.reader.SetTagLength(settings.tagLength)
+    return nil
 }
 
 // Creation of a new Decryptor
@@ -66,30 +175,114 @@ func NewOnlineDecryptor(deviceName, decryptionKey string) OnlineDecryptor {
 // * decryptionKey: your smarty key
 // Return:
 // * Decryptor: a new object to execute methods on
-func NewDecryptor(decryptionKey string) Decryptor {
-    decodedKey, err := hex.DecodeString(decryptionKey)
+// * err: ErrInvalidKey if the key is not a 32 character hex string
+func NewDecryptor(decryptionKey string) (Decryptor, error) {
+    return NewDecryptorWithConfig(decryptionKey, DefaultSecurityConfig())
+}
+
+// Creation of a new Decryptor for other security settings than the smarty ones
+// Parameter:
+// * decryptionKey: the key, as 32 character hex string
+// * config: the authentication key, tag length and expected security control byte
+// Return:
+// * Decryptor: a new object to execute methods on
+// * err: ErrInvalidKey or ErrInvalidSecurityConfig if the Decryptor could not be created
+func NewDecryptorWithConfig(decryptionKey string, config SecurityConfig) (Decryptor, error) {
+    decodedKey, err := decodeKey(decryptionKey)
+    if err != nil {
+        return Decryptor{}, err
+    }
+    settings, err := newSecurity(config)
     if err != nil {
-        glog.Errorf("Error parsing the decryption key: %s", err.Error())
+        return Decryptor{}, err
     }
-    if len(decryptionKey) != 32 {
-        glog.Errorf("Invalid decryption key length\n"+
-            "Required 32 characters\n"+
-            "Found %v characters", len(decryptionKey))
+    return newDecryptor(decodedKey, settings)
+}
+
+func newDecryptor(key []byte, settings security) (Decryptor, error) {
+    cipherBlock, err := aes.NewCipher(key)
+    if err != nil {
+        return Decryptor{}, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+    }
+    aesgcm, err := cipher.NewGCMWithTagSize(cipherBlock, settings.tagLength)
+    if err != nil {
+        return Decryptor{}, fmt.Errorf("%w: %v", ErrInvalidSecurityConfig, err)
     }
-    decodedAad, _ := hex.DecodeString("3000112233445566778899AABBCCDDEEFF")
     return Decryptor{
-        key: decodedKey,
-        aad: decodedAad,
+        key:      key,
+        security: settings,
+        block:    cipherBlock,
+        aead:     aesgcm,
+    }, nil
+}
+
+func decodeKey(key string) ([]byte, error) {
+    if len(key) != 32 {
+        return nil, fmt.Errorf("%w: required 32 characters, found %v characters", ErrInvalidKey, len(key))
+    }
+    decodedKey, err := hex.DecodeString(key)
+    if err != nil {
+        // The error of the hex package would reveal a character of the key
+        return nil, fmt.Errorf("%w: not a hex string", ErrInvalidKey)
     }
+    return decodedKey, nil
+}
+
+// Waits for the next telegram and decrypts it, unencrypted telegrams are returned as they are
+// Return:
+// * plaintText: the decrypted text
+// * err: ErrFraming, ErrAuthFailed or ErrReplay if the telegram was dropped, otherwise the error of the stream
+func (od *OnlineDecryptor) GetTelegram() (plainText []byte, err error) {
+    return od.GetTelegramContext(context.Background())
 }
 
-// Waits for the next telegram and decrypts it
+// Waits for the next telegram and decrypts it, until the context is cancelled or its deadline expires
+// Disconnect may be called from another goroutine to abort the wait during shutdown.
+// Parameter:
+// * ctx: the context limiting the wait
 // Return:
 // * plaintText: the decrypted text
-// * ok: true if the decryption was successful
-func (od *OnlineDecryptor) GetTelegram() (plainText []byte, ok bool) {
-    readTelegram(od.deviceInfo.reader)
-    return od.decryptor.Decrypt(prepareCipherComponents())
+// * err: the context error if the context ended first, otherwise the same errors as GetTelegram
+func (od *OnlineDecryptor) GetTelegramContext(ctx context.Context) (plainText []byte, err error) {
+    telegram, err := od.ReadTelegram(ctx)
+    return telegram.PlainText, err
+}
+
+// Waits for the next telegram and decrypts it, returning the telegram with its tokens and frame counter checks
+// Parameter:
+// * ctx: the context limiting the wait
+// Return:
+// * telegram: the decrypted telegram
+// * err: the same errors as GetTelegramContext
+func (od *OnlineDecryptor) ReadTelegram(ctx context.Context) (telegram Telegram, err error) {
+    frame, err := od.deviceInfo.reader.ReadTelegramContext(ctx)
+    if err != nil {
+        return Telegram{}, err
+    }
+    telegram = Telegram{Frame: frame, ReceivedAt: time.Now()}
+    if !frame.Encrypted() {
+        // Unencrypted telegrams carry no frame counter
+        telegram.PlainText = frame.Unencrypted
+        return telegram, nil
+    }
+    telegram.PlainText, err = od.decryptor.DecryptFrame(frame)
+    if err != nil {
+        return Telegram{}, err
+    }
+
+    telegram.MissedTelegrams, err = od.replayGuard.Check(frame)
+    if err != nil {
+        if od.replayPolicy == ReplayReject || !errors.Is(err, ErrReplay) {
+            return Telegram{}, err
+        }
+        telegram.Replayed = true
+    }
+    // Only authenticated frame counters are remembered, a forged telegram without tag (0x20) could otherwise lock
+    // out the genuine telegrams by a high frame counter
+    if frame.SecurityControl&SecurityAuthentication != 0 {
+        od.replayGuard.Accept(frame)
+    }
+    return telegram, nil
 }
 
 func (od *OnlineDecryptor) getDeviceInfo() deviceInfo {
@@ -102,33 +295,91 @@ func (od *OnlineDecryptor) getDeviceInfo() deviceInfo {
 // * cipherText: this expects the payload with appended gcm tag at the end (payload + gcmTag)!
 // Return:
 // * plaintText: the decrypted text
-// * ok: true if the decryption was successful
-func (d Decryptor) Decrypt(initialValue, cipherText []byte) (plainText []byte, ok bool) {
-    cipherBlock, err := aes.NewCipher(d.key)
-    if err != nil {
-        glog.Fatalln(err.Error())
+// * err: ErrFraming if the components are malformed, ErrAuthFailed if the telegram could not be authenticated
+func (d Decryptor) Decrypt(initialValue, cipherText []byte) (plainText []byte, err error) {
+    return d.DecryptInto(nil, initialValue, cipherText)
+}
+
+// Decrypt a smarty telegram into an existing buffer, without allocating if the buffer is large enough
+// Parameter:
+// * dst: the buffer to reuse, its content is overwritten. It must not overlap the cipher text.
+// * initialValue: the initial value as specified in the smarty documentation
+// * cipherText: this expects the payload with appended gcm tag at the end (payload + gcmTag)!
+// Return:
+// * plaintText: the decrypted text, stored in dst if its capacity suffices
+// * err: the same errors as Decrypt
+func (d Decryptor) DecryptInto(dst, initialValue, cipherText []byte) (plainText []byte, err error) {
+    // Without a telegram at hand, the security control byte of the settings applies
+    securityControl := d.security.securityControl
+    if securityControl == 0 {
+        securityControl = SecurityAuthenticatedEncryption
     }
+    return d.decrypt(dst[:0], securityControl, initialValue, cipherText)
+}
 
-    aesgcm, err := cipher.NewGCMWithTagSize(cipherBlock, 12)
-    if err != nil {
-        glog.Fatalln(err.Error())
+// Decrypt or authenticate a telegram according to its security control byte
+// Parameter:
+// * frame: the tokens of the telegram
+// Return:
+// * plaintText: the decrypted text, or the payload of telegrams which are only authenticated
+// * err: ErrFraming if the tokens are malformed, ErrAuthFailed if the telegram could not be authenticated
+//      or does not use the expected security control byte
+func (d Decryptor) DecryptFrame(frame Frame) (plainText []byte, err error) {
+    if err = d.security.accepts(frame.SecurityControl); err != nil {
+        return nil, err
+    }
+    return d.decrypt(nil, frame.SecurityControl, frame.InitialValue(), frame.CipherText())
+}
+
+func (d Decryptor) decrypt(dst []byte, securityControl byte, initialValue, cipherText []byte) (plainText []byte,
+    err error) {
+    if d.aead == nil {
+        return nil, fmt.Errorf("%w: no decryption key", ErrInvalidKey)
+    }
+    if len(initialValue) != d.aead.NonceSize() {
+        return nil, fmt.Errorf("%w: initial value of %v bytes, expected %v bytes",
+            ErrFraming, len(initialValue), d.aead.NonceSize())
+    }
+    tagLength := tagLengthOf(securityControl, d.security.tagLength)
+    if len(cipherText) < tagLength {
+        return nil, fmt.Errorf("%w: cipher text shorter than the gcm tag", ErrFraming)
+    }
+    payload, tag := cipherText[:len(cipherText)-tagLength], cipherText[len(cipherText)-tagLength:]
+
+    aad := d.security.additionalData(securityControl)
+    switch securityControl & SecurityAuthenticatedEncryption {
+    case SecurityAuthentication:
+        // The payload is not encrypted, the tag covers it as additional authenticated data
+        if _, err = d.aead.Open(nil, initialValue, tag, append(append([]byte{}, aad...), payload...)); err != nil {
+            return nil, fmt.Errorf("%w: %v", ErrAuthFailed, err)
+        }
+        return append(dst, payload...), nil
+    case SecurityEncryption:
+        // Without tag the payload can not be authenticated, it is only decrypted
+        plainText = append(dst, payload...)
+        cipher.NewCTR(d.block, counterBlock(initialValue)).XORKeyStream(plainText, plainText)
+        return plainText, nil
     }
 
-    plaintext, err := aesgcm.Open(nil, initialValue, cipherText, d.aad)
+    plainText, err = d.aead.Open(dst, initialValue, cipherText, aad)
     if err != nil {
-        glog.Errorln(err.Error())
+        return nil, fmt.Errorf("%w: %v", ErrAuthFailed, err)
     }
+    return plainText, nil
+}
 
-    return plaintext, err == nil
+// Records every byte read from the device into the capture, until the capture is set to nil
+// Parameter:
+// * capture: the capture to write to, nil to stop capturing
+func (od *OnlineDecryptor) SetCapture(capture *CaptureWriter) {
+    od.deviceInfo.setCapture(capture)
 }
 
-// Disconnect the serial connection
+// Disconnect the serial connection and drop the decryption key, telegrams can not be decrypted afterwards
+// The decoded key is overwritten, the cipher holding the expanded key is left to the garbage collector. The key
+// string passed on creation is immutable and can not be overwritten.
 func (od *OnlineDecryptor) Disconnect() {
-    err := od.port.Close()
-    if err == nil {
-        glog.Infoln("Serial connection closed")
-    } else {
-        glog.Errorln("Unable to close serial connection")
-    }
-
+    od.deviceInfo.disconnect()
+    wipeKey(od.decryptor.key)
+    od.decryptor = Decryptor{}
 }
@@ -18,21 +18,138 @@
 package smarty_test
 
 import (
+    "bytes"
+    "errors"
     "testing"
+    "time"
 
     "github.com/NEXXTLAB/go-smarty-reader/smarty"
 )
 
 // Test if the decryption is possible with the provided key and pre-recorded telegram (found in Smarty_test.go)
 func TestDecryption(t *testing.T) {
-    smartyObj := smarty.NewDecryptor(string(key))
+    smartyObj, err := smarty.NewDecryptor(string(key))
+    if err != nil {
+        t.Fatal(err)
+    }
     iv := append(systemTitle, frameCounter...)
     cipher := append(payload, gcmTag...)
-    plainText, ok := smartyObj.Decrypt(iv, cipher)
+    plainText, err := smartyObj.Decrypt(iv, cipher)
 
-    if ok {
+    if err == nil {
         t.Logf("Decryption success: \n%s\n", plainText)
     } else {
-        t.Error("Decryption failed!")
+        t.Errorf("Decryption failed! %s", err)
+    }
+}
+
+// Test if invalid keys and modified telegrams are reported with the matching errors
+func TestDecryptionErrors(t *testing.T) {
+    for _, invalidKey := range []string{"", "D491470F47126332B07D1923B35041", "X491470F47126332B07D1923B3504188"} {
+        if _, err := smarty.NewDecryptor(invalidKey); !errors.Is(err, smarty.ErrInvalidKey) {
+            t.Errorf("Expected ErrInvalidKey for key %q, got %v", invalidKey, err)
+        }
+    }
+
+    smartyObj, err := smarty.NewDecryptor(string(key))
+    if err != nil {
+        t.Fatal(err)
+    }
+    iv := append(append([]byte{}, systemTitle...), frameCounter...)
+    cipher := append(append([]byte{}, payload...), gcmTag...)
+    cipher[0] ^= 0x01
+    if _, err := smartyObj.Decrypt(iv, cipher); !errors.Is(err, smarty.ErrAuthFailed) {
+        t.Errorf("Expected ErrAuthFailed, got %v", err)
+    }
+    if _, err := smartyObj.Decrypt(iv[:8], cipher); !errors.Is(err, smarty.ErrFraming) {
+        t.Errorf("Expected ErrFraming for a short initial value, got %v", err)
+    }
+}
+
+// Test if the key is dropped on Disconnect, further telegrams are no longer decrypted
+func TestDisconnectDropsKey(t *testing.T) {
+    input := append(append([]byte{}, telegram[:]...), telegram[:]...)
+    smartyObj, err := smarty.NewOnlineDecryptorFromReader(bytes.NewReader(input), key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err = smartyObj.GetTelegram(); err != nil {
+        t.Fatal(err)
+    }
+    smartyObj.Disconnect()
+    if _, err = smartyObj.GetTelegram(); !errors.Is(err, smarty.ErrInvalidKey) {
+        t.Errorf("Expected ErrInvalidKey after Disconnect, got %v", err)
+    }
+}
+
+// Test if DecryptInto reuses the provided buffer and yields the same plain text as Decrypt
+func TestDecryptInto(t *testing.T) {
+    smartyObj, err := smarty.NewDecryptor(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    iv := append(append([]byte{}, systemTitle...), frameCounter...)
+    cipher := append(append([]byte{}, payload...), gcmTag...)
+    expected, err := smartyObj.Decrypt(iv, cipher)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    buffer := make([]byte, 0, 1024)
+    plainText, err := smartyObj.DecryptInto(buffer, iv, cipher)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(plainText, expected) || &plainText[0] != &buffer[:1][0] {
+        t.Error("DecryptInto did not decrypt into the provided buffer")
+    }
+    allocations := testing.AllocsPerRun(100, func() {
+        smartyObj.DecryptInto(buffer, iv, cipher)
+    })
+    if allocations > 0 {
+        t.Errorf("DecryptInto allocated %v times per telegram", allocations)
+    }
+}
+
+// Measure the decryption of the pre-recorded telegram, allocating the plain text for each telegram
+func BenchmarkDecrypt(b *testing.B) {
+    smartyObj, err := smarty.NewDecryptor(key)
+    if err != nil {
+        b.Fatal(err)
+    }
+    iv := append(append([]byte{}, systemTitle...), frameCounter...)
+    cipher := append(append([]byte{}, payload...), gcmTag...)
+
+    b.SetBytes(int64(len(payload)))
+    b.ReportAllocs()
+    b.ResetTimer()
+    start := time.Now()
+    for i := 0; i < b.N; i++ {
+        if _, err := smartyObj.Decrypt(iv, cipher); err != nil {
+            b.Fatal(err)
+        }
+    }
+    b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "telegrams/s")
+}
+
+// Measure the decryption of the pre-recorded telegram into a reused buffer
+func BenchmarkDecryptInto(b *testing.B) {
+    smartyObj, err := smarty.NewDecryptor(key)
+    if err != nil {
+        b.Fatal(err)
+    }
+    iv := append(append([]byte{}, systemTitle...), frameCounter...)
+    cipher := append(append([]byte{}, payload...), gcmTag...)
+    buffer := make([]byte, 0, len(payload))
+
+    b.SetBytes(int64(len(payload)))
+    b.ReportAllocs()
+    b.ResetTimer()
+    start := time.Now()
+    for i := 0; i < b.N; i++ {
+        if _, err := smartyObj.DecryptInto(buffer, iv, cipher); err != nil {
+            b.Fatal(err)
+        }
     }
+    b.ReportMetric(float64(b.N)/time.Since(start).Seconds(), "telegrams/s")
 }
@@ -0,0 +1,160 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   The Encoder is the inverse of the Decryptor and the FrameParser: it encrypts a plain text telegram and frames it
+   exactly like the smarty does, which allows generating test vectors for any key or emulating a meter.
+*/
+
+package smarty
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/binary"
+	"fmt"
+)
+
+// Struct allowing to build encrypted smarty telegrams
+type Encoder struct {
+	key         []byte
+	security    security
+	systemTitle []byte
+}
+
+// Creation of a new Encoder
+// Parameter:
+// * encryptionKey: the smarty key, as 32 character hex string
+// * systemTitle: the 8 byte system title of the emulated meter
+// Return:
+// * Encoder: a new object to execute methods on
+// * err: ErrInvalidKey if the key could not be parsed, ErrFraming if the system title is not 8 bytes long
+func NewEncoder(encryptionKey string, systemTitle []byte) (*Encoder, error) {
+	return NewEncoderWithConfig(encryptionKey, systemTitle, DefaultSecurityConfig())
+}
+
+// Creation of a new Encoder for other security settings than the smarty ones
+// Parameter:
+// * encryptionKey: the key, as 32 character hex string
+// * systemTitle: the 8 byte system title of the emulated meter
+// * config: the authentication key, tag length and security control byte (0x10, 0x20 or 0x30) to use
+// Return:
+// * Encoder: a new object to execute methods on
+// * err: ErrInvalidKey, ErrInvalidSecurityConfig or ErrFraming if the Encoder could not be created
+func NewEncoderWithConfig(encryptionKey string, systemTitle []byte, config SecurityConfig) (*Encoder, error) {
+	decodedKey, err := decodeKey(encryptionKey)
+	if err != nil {
+		return nil, err
+	}
+	settings, err := newSecurity(config)
+	if err != nil {
+		return nil, err
+	}
+	if settings.securityControl == 0 {
+		return nil, fmt.Errorf("%w: the Encoder requires a security control byte", ErrInvalidSecurityConfig)
+	}
+	if len(systemTitle) != systemTitleLength {
+		return nil, fmt.Errorf("%w: system title of %v bytes, expected %v bytes",
+			ErrFraming, len(systemTitle), systemTitleLength)
+	}
+	return &Encoder{
+		key:         decodedKey,
+		security:    settings,
+		systemTitle: append([]byte{}, systemTitle...),
+	}, nil
+}
+
+// Encrypts a plain text telegram and splits the result into its tokens
+// Parameter:
+// * plainText: the telegram as sent by the meter, from '/' up to and including the checksum line
+// * frameCounter: the frame counter of this telegram, it must never repeat for the same key
+// Return:
+// * frame: the tokens of the encrypted telegram
+// * err: error if the telegram could not be encrypted
+func (e *Encoder) EncryptFrame(plainText []byte, frameCounter uint32) (frame Frame, err error) {
+	cipherBlock, err := aes.NewCipher(e.key)
+	if err != nil {
+		return Frame{}, fmt.Errorf("%w: %v", ErrInvalidKey, err)
+	}
+	aesgcm, err := cipher.NewGCMWithTagSize(cipherBlock, e.security.tagLength)
+	if err != nil {
+		return Frame{}, err
+	}
+
+	frame.SecurityControl = e.security.securityControl
+	frame.SystemTitle = append([]byte{}, e.systemTitle...)
+	frame.FrameCounter = make([]byte, 4)
+	binary.BigEndian.PutUint32(frame.FrameCounter, frameCounter)
+	aad := e.security.additionalData(frame.SecurityControl)
+	switch frame.SecurityControl & SecurityAuthenticatedEncryption {
+	case SecurityAuthenticatedEncryption:
+		sealed := aesgcm.Seal(nil, frame.InitialValue(), plainText, aad)
+		frame.Payload = sealed[:len(plainText)]
+		frame.GCMTag = sealed[len(plainText):]
+	case SecurityAuthentication:
+		// The tag covers the plain text payload as additional authenticated data
+		frame.Payload = append([]byte{}, plainText...)
+		frame.GCMTag = aesgcm.Seal(nil, frame.InitialValue(), nil, append(append([]byte{}, aad...), plainText...))
+	case SecurityEncryption:
+		frame.Payload = make([]byte, len(plainText))
+		cipher.NewCTR(cipherBlock, counterBlock(frame.InitialValue())).XORKeyStream(frame.Payload, plainText)
+		frame.GCMTag = []byte{}
+	}
+	return frame, nil
+}
+
+// Encrypts a plain text telegram and frames it like the smarty
+// Parameter:
+// * plainText: the telegram as sent by the meter, from '/' up to and including the checksum line
+// * frameCounter: the frame counter of this telegram, it must never repeat for the same key
+// Return:
+// * telegram: the bytes as sent over the P1 port
+// * err: error if the telegram could not be encrypted or is too long
+func (e *Encoder) Encode(plainText []byte, frameCounter uint32) (telegram []byte, err error) {
+	frame, err := e.EncryptFrame(plainText, frameCounter)
+	if err != nil {
+		return nil, err
+	}
+	return frame.Bytes()
+}
+
+// Returns the frame in the format sent over the P1 port
+// Return:
+// * telegram: start byte 0xDB, system title, separator 0x82, length, security control byte, frame counter, payload,
+//      gcm tag
+// * err: ErrFraming if a token is too long to be framed
+func (f Frame) Bytes() (telegram []byte, err error) {
+	// Frames built without a security control byte use the one of the smarty
+	securityControl := f.SecurityControl
+	if securityControl == 0 {
+		securityControl = SecurityAuthenticatedEncryption
+	}
+	// The length covers security control byte, frame counter, payload and gcm tag
+	dataLength := 1 + len(f.FrameCounter) + len(f.Payload) + len(f.GCMTag)
+	if len(f.SystemTitle) > 0xFF || dataLength > 0xFFFF {
+		return nil, fmt.Errorf("%w: telegram too long to be framed", ErrFraming)
+	}
+
+	telegram = make([]byte, 0, 5+len(f.SystemTitle)+dataLength)
+	telegram = append(telegram, 0xDB, byte(len(f.SystemTitle)))
+	telegram = append(telegram, f.SystemTitle...)
+	telegram = append(telegram, 0x82, byte(dataLength>>8), byte(dataLength), securityControl)
+	telegram = append(telegram, f.FrameCounter...)
+	telegram = append(telegram, f.Payload...)
+	telegram = append(telegram, f.GCMTag...)
+	return telegram, nil
+}
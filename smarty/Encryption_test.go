@@ -0,0 +1,84 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package smarty_test
+
+import (
+    "bytes"
+    "encoding/binary"
+    "testing"
+
+    "github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+// Test if re-encoding the decrypted pre-recorded telegram reproduces the bytes sent by the meter
+func TestEncodeRecordedTelegram(t *testing.T) {
+    decryptor, err := smarty.NewDecryptor(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    plainText, err := decryptor.Decrypt(append(append([]byte{}, systemTitle...), frameCounter...),
+        append(append([]byte{}, payload...), gcmTag...))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    encoder, err := smarty.NewEncoder(key, systemTitle)
+    if err != nil {
+        t.Fatal(err)
+    }
+    encoded, err := encoder.Encode(plainText, binary.BigEndian.Uint32(frameCounter))
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(encoded, telegram[:]) {
+        t.Error("Encoded telegram differs from the recorded telegram")
+    }
+}
+
+// Test if a telegram encoded with another key and system title passes through the OnlineDecryptor
+func TestEncodeRoundTrip(t *testing.T) {
+    otherKey := "000102030405060708090A0B0C0D0E0F"
+    plainText := []byte("/Lux5\\253663629_D\r\n\r\n1-0:1.8.0(000123.456*kWh)\r\n!\r\n")
+    encoder, err := smarty.NewEncoder(otherKey, []byte("XYZ12345"))
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    var stream bytes.Buffer
+    for frameCounter := uint32(1); frameCounter <= 3; frameCounter++ {
+        encoded, err := encoder.Encode(plainText, frameCounter)
+        if err != nil {
+            t.Fatal(err)
+        }
+        stream.Write(encoded)
+    }
+
+    smartyObj, err := smarty.NewOnlineDecryptorFromReader(&stream, otherKey)
+    if err != nil {
+        t.Fatal(err)
+    }
+    for i := 0; i < 3; i++ {
+        decrypted, err := smartyObj.GetTelegram()
+        if err != nil {
+            t.Fatalf("Telegram %d: %s", i+1, err)
+        }
+        if !bytes.Equal(decrypted, plainText) {
+            t.Errorf("Telegram %d: unexpected plain text %q", i+1, decrypted)
+        }
+    }
+}
@@ -0,0 +1,52 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   The errors returned by this package. Errors carrying additional details wrap one of the sentinel errors below,
+   compare them using errors.Is.
+*/
+
+package smarty
+
+import (
+	"errors"
+)
+
+var (
+	// The decryption key is not a valid 16 byte hex string
+	ErrInvalidKey = errors.New("smarty: invalid decryption key")
+	// The telegram could not be authenticated, either the key is wrong or the telegram was modified
+	ErrAuthFailed = errors.New("smarty: telegram authentication failed")
+	// The byte stream does not follow the expected telegram structure
+	ErrFraming = errors.New("smarty: invalid telegram framing")
+	// The keyring holds no decryption key for the system title of the telegram
+	ErrUnknownMeter = errors.New("smarty: no decryption key for meter")
+	// The serial device could not be opened
+	ErrDeviceUnavailable = errors.New("smarty: serial device unavailable")
+	// The serial line settings are not supported
+	ErrInvalidSerialConfig = errors.New("smarty: invalid serial line settings")
+	// The security settings (authentication key, tag length, security control byte) are not supported
+	ErrInvalidSecurityConfig = errors.New("smarty: invalid security settings")
+	// The connection has been closed using Disconnect
+	ErrClosed = errors.New("smarty: connection closed")
+	// A telegram was dropped since the consumer did not keep up
+	ErrOverflow = errors.New("smarty: telegram buffer overflow")
+	// The frame counter of the telegram did not increase, it has been replayed or the counter rolled back
+	ErrReplay = errors.New("smarty: replayed telegram")
+	// The input is not a capture file or the capture is damaged
+	ErrCaptureFormat = errors.New("smarty: invalid capture")
+)
@@ -23,7 +23,8 @@
 package smarty
 
 import (
-    "github.com/golang/glog"
+    "context"
+    "io"
 )
 
 // Struct allowing to retrieve smarty telegrams, split into initial value, cipher text and gcm tag
@@ -36,15 +37,35 @@ type CipherForwarder struct {
 // * deviceName: the port to listen to
 // Return:
 // * CipherForwarder: a new object to execute methods on
-func NewCipherForwarder(deviceName string) CipherForwarder {
-    reader, port := openSerialConnection(deviceName)
-    glog.Infoln("Serial connection established")
-    return CipherForwarder{
-        deviceInfo: deviceInfo{
-            deviceName: deviceName,
-            reader:     reader,
-            port:       port,
-        },
+// * err: ErrDeviceUnavailable if the serial device could not be opened
+func NewCipherForwarder(deviceName string) (*CipherForwarder, error) {
+    return NewCipherForwarderWithConfig(DefaultSerialConfig(deviceName))
+}
+
+// Creation of a new CipherForwarder with custom serial line settings
+// Parameter:
+// * config: the port to listen to and its settings, see DefaultSerialConfig
+// Return:
+// * CipherForwarder: a new object to execute methods on
+// * err: ErrDeviceUnavailable if the serial device could not be opened
+func NewCipherForwarderWithConfig(config SerialConfig) (*CipherForwarder, error) {
+    deviceInfo, err := newSerialDeviceInfo(config)
+    if err != nil {
+        return nil, err
+    }
+    return &CipherForwarder{
+        deviceInfo: deviceInfo,
+    }, nil
+}
+
+// Creation of a new CipherForwarder reading from an arbitrary byte stream instead of a serial port
+// Parameter:
+// * input: the byte stream to read from (TCP socket, pipe, recorded file, ...), closed on Disconnect if possible
+// Return:
+// * CipherForwarder: a new object to execute methods on
+func NewCipherForwarderFromReader(input io.Reader) *CipherForwarder {
+    return &CipherForwarder{
+        deviceInfo: newStreamDeviceInfo(input),
     }
 }
 
@@ -54,26 +75,46 @@ func NewCipherForwarder(deviceName string) CipherForwarder {
 // * initialValue: the initial value as specified in the smarty documentation
 // * cipherText: the payload
 // * gcmTag: the aes-gcm tag
-func (cf *CipherForwarder) GetTelegram() (initialValue, cipherText, gcmTag []byte) {
-    readTelegram(cf.deviceInfo.reader)
-    return cf.forwardTelegram()
+// * err: ErrFraming if the telegram was dropped, otherwise the error of the underlying stream
+func (cf *CipherForwarder) GetTelegram() (initialValue, cipherText, gcmTag []byte, err error) {
+    return cf.GetTelegramContext(context.Background())
+}
+
+// Waits for the next telegram and splits it into its tokens, until the context is cancelled or its deadline expires
+// Disconnect may be called from another goroutine to abort the wait during shutdown.
+// Parameter:
+// * ctx: the context limiting the wait
+// Return:
+// * initialValue, cipherText, gcmTag: see GetTelegram
+// * err: the context error if the context ended first, otherwise the same errors as GetTelegram
+func (cf *CipherForwarder) GetTelegramContext(ctx context.Context) (initialValue, cipherText, gcmTag []byte,
+    err error) {
+    frame, err := cf.deviceInfo.reader.ReadTelegramContext(ctx)
+    if err != nil {
+        return nil, nil, nil, err
+    }
+    return frame.InitialValue(), frame.Payload, frame.GCMTag, nil
+}
+
+// Sets the length of the authentication tag for meters other than the smarty
+// Parameter:
+// * tagLength: 12 (smarty, default) or 16 bytes
+func (cf *CipherForwarder) SetTagLength(tagLength int) {
+    cf.deviceInfo.reader.SetTagLength(tagLength)
 }
 
 func (cf *CipherForwarder) getDeviceInfo() deviceInfo {
     return cf.deviceInfo
 }
 
-func (cf *CipherForwarder) forwardTelegram() (iv, cipherText, gcmTag []byte) {
-    iv, cipherText = prepareCipherComponents()
-    return iv, cipherText[:len(cipherText)-GCMTagLength], cipherText[len(cipherText)-GCMTagLength:]
+// Records every byte read from the device into the capture, until the capture is set to nil
+// Parameter:
+// * capture: the capture to write to, nil to stop capturing
+func (cf *CipherForwarder) SetCapture(capture *CaptureWriter) {
+    cf.deviceInfo.setCapture(capture)
 }
 
 // Disconnect the serial connection
 func (cf *CipherForwarder) Disconnect() {
-    err := cf.port.Close()
-    if err == nil {
-        glog.Infoln("Serial connection closed")
-    } else {
-        glog.Errorln("Unable to close serial connection")
-    }
+    cf.deviceInfo.disconnect()
 }
@@ -0,0 +1,173 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   The forwarding protocol ships the encrypted telegrams read by a CipherForwarder to a remote decryptor, the key
+   therefore never has to be stored on the device attached to the meter. A message holds:
+
+       version 0x01 (1 byte), tag length (1 byte), receive time (8 bytes, big endian, Unix time in nanoseconds),
+       telegram as sent over the P1 port (see Frame.Bytes)
+
+   Over a byte stream (eg. TCP) every message is preceded by its length (4 bytes, big endian), MQTT payloads hold
+   a single message without length.
+*/
+package smarty
+
+import (
+	"bufio"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	forwardingVersion = 1
+	// Version, tag length and receive time
+	forwardingHeaderLength = 10
+	// Upper limit of a message, a telegram is limited to 64 kB by its length field
+	maxForwardingLength = 1 << 17
+)
+
+// Converts a telegram into a forwarding message
+// Parameter:
+// * telegram: the telegram as returned by the CipherForwarder
+// Return:
+// * message: the message, eg. to be published as MQTT payload
+// * err: ErrFraming if the telegram can not be framed
+func MarshalForwardedTelegram(telegram Telegram) (message []byte, err error) {
+	frame, err := telegram.Frame.Bytes()
+	if err != nil {
+		return nil, err
+	}
+	message = make([]byte, 0, forwardingHeaderLength+len(frame))
+	message = append(message, forwardingVersion, byte(len(telegram.GCMTag)))
+	message = appendUint64(message, uint64(telegram.ReceivedAt.UnixNano()))
+	return append(message, frame...), nil
+}
+
+// Converts a forwarding message back into a telegram
+// Parameter:
+// * message: the message as created by MarshalForwardedTelegram
+// Return:
+// * telegram: the tokens and receive time of the telegram, not yet decrypted
+// * err: ErrFraming if the message is malformed
+func UnmarshalForwardedTelegram(message []byte) (telegram Telegram, err error) {
+	if len(message) < forwardingHeaderLength {
+		return Telegram{}, fmt.Errorf("%w: forwarding message of %d bytes", ErrFraming, len(message))
+	}
+	if message[0] != forwardingVersion {
+		return Telegram{}, fmt.Errorf("%w: unsupported forwarding version %d", ErrFraming, message[0])
+	}
+	parser := NewFrameParser()
+	parser.SetTagLength(int(message[1]))
+	consumed, ready, err := parser.processByteStream(message[forwardingHeaderLength:])
+	if err != nil {
+		return Telegram{}, err
+	}
+	if !ready || consumed != len(message)-forwardingHeaderLength {
+		return Telegram{}, fmt.Errorf("%w: forwarding message does not hold a single telegram", ErrFraming)
+	}
+	return Telegram{
+		Frame:      parser.frame(),
+		ReceivedAt: time.Unix(0, int64(binary.BigEndian.Uint64(message[2:forwardingHeaderLength]))),
+	}, nil
+}
+
+// Struct writing forwarding messages to a byte stream, eg. a TCP connection
+// A ForwardingWriter is safe for concurrent use.
+type ForwardingWriter struct {
+	writer io.Writer
+	mutex  sync.Mutex
+}
+
+// Creation of a new ForwardingWriter
+// Parameter:
+// * output: the byte stream to write the messages to
+// Return:
+// * ForwardingWriter: a new object to execute methods on
+func NewForwardingWriter(output io.Writer) *ForwardingWriter {
+	return &ForwardingWriter{writer: output}
+}
+
+// Writes a telegram, preceded by the length of the message
+// Parameter:
+// * telegram: the telegram as returned by the CipherForwarder
+// Return:
+// * err: ErrFraming if the telegram can not be framed, otherwise the error of the byte stream
+func (fw *ForwardingWriter) WriteTelegram(telegram Telegram) error {
+	message, err := MarshalForwardedTelegram(telegram)
+	if err != nil {
+		return err
+	}
+	buffer := make([]byte, 4, 4+len(message))
+	binary.BigEndian.PutUint32(buffer, uint32(len(message)))
+	buffer = append(buffer, message...)
+
+	fw.mutex.Lock()
+	defer fw.mutex.Unlock()
+	_, err = fw.writer.Write(buffer)
+	return err
+}
+
+// Struct reading forwarding messages from a byte stream, eg. a TCP connection
+type ForwardingReader struct {
+	reader *bufio.Reader
+	// Set once the stream is out of sync, returned by every further call
+	err error
+}
+
+// Creation of a new ForwardingReader
+// Parameter:
+// * input: the byte stream to read the messages from
+// Return:
+// * ForwardingReader: a new object to execute methods on
+func NewForwardingReader(input io.Reader) *ForwardingReader {
+	return &ForwardingReader{reader: bufio.NewReader(input)}
+}
+
+// Waits for the next message
+// Return:
+// * telegram: the tokens and receive time of the telegram, not yet decrypted
+// * err: ErrFraming if the message is malformed, the next call continues after it. If the length of the message
+//      is out of range, the stream is out of sync and every further call returns the same ErrFraming. io.EOF if the
+//      stream ended between two messages, io.ErrUnexpectedEOF if it ended within a message, or the error of the
+//      byte stream.
+func (fr *ForwardingReader) ReadTelegram() (telegram Telegram, err error) {
+	if fr.err != nil {
+		return Telegram{}, fr.err
+	}
+	var length [4]byte
+	if _, err = io.ReadFull(fr.reader, length[:]); err != nil {
+		return Telegram{}, err
+	}
+	messageLength := binary.BigEndian.Uint32(length[:])
+	if messageLength > maxForwardingLength {
+		// Without a valid length the following messages can not be found
+		fr.err = fmt.Errorf("%w: forwarding message of %d bytes, stream out of sync", ErrFraming, messageLength)
+		return Telegram{}, fr.err
+	}
+	message := make([]byte, messageLength)
+	if _, err = io.ReadFull(fr.reader, message); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return Telegram{}, err
+	}
+	return UnmarshalForwardedTelegram(message)
+}
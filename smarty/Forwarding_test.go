@@ -0,0 +1,104 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package smarty_test
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "testing"
+    "time"
+
+    "github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+// Test if the pre-recorded telegram survives the forwarding protocol and is decrypted on the other side
+func TestForwarding(t *testing.T) {
+    frame, err := smarty.NewTelegramReader(bytes.NewReader(telegram[:])).ReadTelegram()
+    if err != nil {
+        t.Fatal(err)
+    }
+    sent := smarty.Telegram{Frame: frame, ReceivedAt: time.Unix(1600000000, 123)}
+
+    var stream bytes.Buffer
+    writer := smarty.NewForwardingWriter(&stream)
+    for i := 0; i < 2; i++ {
+        if err = writer.WriteTelegram(sent); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    decryptor, err := smarty.NewDecryptor(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    reader := smarty.NewForwardingReader(&stream)
+    for i := 0; i < 2; i++ {
+        received, err := reader.ReadTelegram()
+        if err != nil {
+            t.Fatal(err)
+        }
+        if !received.ReceivedAt.Equal(sent.ReceivedAt) || received.Tuple() != frame.Tuple() {
+            t.Errorf("Received telegram %s at %s differs from the sent one", received.Tuple(), received.ReceivedAt)
+        }
+        if _, err = decryptor.DecryptFrame(received.Frame); err != nil {
+            t.Errorf("Received telegram could not be decrypted: %s", err)
+        }
+    }
+    if _, err = reader.ReadTelegram(); err != io.EOF {
+        t.Errorf("Expected io.EOF after the last message, got %v", err)
+    }
+}
+
+// Test if malformed messages are rejected
+func TestForwardingMalformed(t *testing.T) {
+    frame, err := smarty.NewTelegramReader(bytes.NewReader(telegram[:])).ReadTelegram()
+    if err != nil {
+        t.Fatal(err)
+    }
+    message, err := smarty.MarshalForwardedTelegram(smarty.Telegram{Frame: frame, ReceivedAt: time.Now()})
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    unknownVersion := append([]byte{0x02}, message[1:]...)
+    trailingBytes := append(append([]byte{}, message...), 0x00)
+    for _, invalid := range [][]byte{nil, message[:10], message[:len(message)-1], unknownVersion, trailingBytes} {
+        if _, err := smarty.UnmarshalForwardedTelegram(invalid); !errors.Is(err, smarty.ErrFraming) {
+            t.Errorf("Expected ErrFraming for a message of %d bytes, got %v", len(invalid), err)
+        }
+    }
+
+    var stream bytes.Buffer
+    if err = smarty.NewForwardingWriter(&stream).WriteTelegram(smarty.Telegram{Frame: frame}); err != nil {
+        t.Fatal(err)
+    }
+    truncated := smarty.NewForwardingReader(bytes.NewReader(stream.Bytes()[:stream.Len()-1]))
+    if _, err = truncated.ReadTelegram(); err != io.ErrUnexpectedEOF {
+        t.Errorf("Expected io.ErrUnexpectedEOF for a truncated stream, got %v", err)
+    }
+
+    // The messages following an oversized length can not be found
+    oversized := smarty.NewForwardingReader(io.MultiReader(bytes.NewReader([]byte{0xFF, 0xFF, 0xFF, 0xFF}),
+        bytes.NewReader(stream.Bytes())))
+    for i := 0; i < 2; i++ {
+        if _, err = oversized.ReadTelegram(); !errors.Is(err, smarty.ErrFraming) {
+            t.Errorf("Expected ErrFraming for an oversized message, got %v", err)
+        }
+    }
+}
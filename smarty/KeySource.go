@@ -0,0 +1,165 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   Passing the decryption key as command-line argument exposes it in the process list and the shell history. The
+   functions below read it from a file, a systemd credential (LoadCredential=), an environment variable or stdin
+   instead. Key files must not be accessible by other users, and keys are only logged in redacted form.
+   The keys are returned as strings, which are immutable in Go: only the decoded key is overwritten on Disconnect,
+   copies of the string remain in memory until the garbage collector reuses it.
+*/
+package smarty
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// Upper limit of a key source, a key is 32 characters long
+const maxKeySourceLength = 4096
+
+// Reads the key from a file, which must not be accessible by group or other users (eg. chmod 600)
+// Parameter:
+// * path: the file holding the key, surrounding whitespace is ignored
+// Return:
+// * key: the key as hex string
+// * err: ErrInvalidKey if the file is accessible by other users or does not hold a key, otherwise the file error
+func ReadKeyFile(path string) (key string, err error) {
+	file, err := openKeyFile(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+	return readKeySource(file, "file "+path)
+}
+
+// Reads the key from a systemd credential, passed to the service using LoadCredential= or SetCredential=
+// Parameter:
+// * name: the name of the credential
+// Return:
+// * key: the key as hex string
+// * err: ErrInvalidKey if the process was not started with credentials, otherwise the same errors as ReadKeyFile
+func ReadKeyCredential(name string) (key string, err error) {
+	directory := os.Getenv("CREDENTIALS_DIRECTORY")
+	if directory == "" {
+		return "", fmt.Errorf("%w: no systemd credentials passed (CREDENTIALS_DIRECTORY not set)", ErrInvalidKey)
+	}
+	return ReadKeyFile(filepath.Join(directory, name))
+}
+
+// Reads the key from an environment variable
+// Parameter:
+// * name: the name of the environment variable, eg. "SMARTY_KEY"
+// Return:
+// * key: the key as hex string
+// * err: ErrInvalidKey if the variable is not set or empty
+func ReadKeyEnv(name string) (key string, err error) {
+	key = strings.TrimSpace(os.Getenv(name))
+	if key == "" {
+		return "", fmt.Errorf("%w: environment variable %s not set", ErrInvalidKey, name)
+	}
+	return key, nil
+}
+
+// Reads the key from the first line of a stream, eg. stdin
+// Only the first line is consumed, the remaining input can still be read by the caller.
+// Parameter:
+// * input: the stream holding the key
+// Return:
+// * key: the key as hex string
+// * err: ErrInvalidKey if the first line does not hold a key, otherwise the error of the stream
+func ReadKey(input io.Reader) (key string, err error) {
+	line := make([]byte, 0, 64)
+	character := make([]byte, 1)
+	for len(line) < maxKeySourceLength {
+		// Reading byte by byte avoids consuming input following the key
+		n, err := input.Read(character)
+		if n == 1 {
+			if character[0] == '\n' {
+				break
+			}
+			line = append(line, character[0])
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return "", err
+		}
+	}
+	return parseKeySource(line, "stdin")
+}
+
+// Returns the key in a form which can be logged, only the first and last 2 characters are kept, eg. "D4...88"
+// Parameter:
+// * key: the key as hex string
+// Return:
+// * redacted: the redacted key
+func RedactKey(key string) (redacted string) {
+	if len(key) < 16 {
+		return "..."
+	}
+	return key[:2] + "..." + key[len(key)-2:]
+}
+
+func readKeySource(input io.Reader, source string) (string, error) {
+	content, err := ioutil.ReadAll(io.LimitReader(input, maxKeySourceLength))
+	if err != nil {
+		return "", err
+	}
+	return parseKeySource(content, source)
+}
+
+func parseKeySource(content []byte, source string) (string, error) {
+	key := strings.TrimSpace(string(content))
+	if key == "" {
+		return "", fmt.Errorf("%w: %s holds no key", ErrInvalidKey, source)
+	}
+	return key, nil
+}
+
+// Opens a key file, rejecting files readable or writable by group or other users. Windows relies on ACLs instead.
+// The permissions are checked on the opened file, it can not be replaced between the check and reading it.
+func openKeyFile(path string) (*os.File, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return nil, err
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0077 != 0 {
+		file.Close()
+		return nil, fmt.Errorf("%w: %s is accessible by other users (%v), restrict it using chmod 600",
+			ErrInvalidKey, path, info.Mode().Perm())
+	}
+	return file, nil
+}
+
+// Overwrites the key in memory once it is no longer used
+func wipeKey(key []byte) {
+	for i := range key {
+		key[i] = 0
+	}
+}
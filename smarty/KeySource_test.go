@@ -0,0 +1,98 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package smarty_test
+
+import (
+    "errors"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "runtime"
+    "strings"
+    "testing"
+
+    "github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+// Test if the key is read from a file, a systemd credential and rejected if other users may access the file
+func TestReadKeyFile(t *testing.T) {
+    directory, err := ioutil.TempDir("", "key")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(directory)
+    path := filepath.Join(directory, "smarty.key")
+    if err = ioutil.WriteFile(path, []byte(key+"\n"), 0600); err != nil {
+        t.Fatal(err)
+    }
+
+    if read, err := smarty.ReadKeyFile(path); err != nil || read != key {
+        t.Errorf("Key file read as %q: %v", read, err)
+    }
+    os.Setenv("CREDENTIALS_DIRECTORY", directory)
+    defer os.Unsetenv("CREDENTIALS_DIRECTORY")
+    if read, err := smarty.ReadKeyCredential("smarty.key"); err != nil || read != key {
+        t.Errorf("Credential read as %q: %v", read, err)
+    }
+
+    if runtime.GOOS != "windows" {
+        if err = os.Chmod(path, 0644); err != nil {
+            t.Fatal(err)
+        }
+        if _, err = smarty.ReadKeyFile(path); !errors.Is(err, smarty.ErrInvalidKey) {
+            t.Errorf("Expected ErrInvalidKey for a key file readable by other users, got %v", err)
+        }
+    }
+}
+
+// Test if the key is read from an environment variable and from the first line of a stream
+func TestReadKey(t *testing.T) {
+    os.Setenv("SMARTY_TEST_KEY", key)
+    defer os.Unsetenv("SMARTY_TEST_KEY")
+    if read, err := smarty.ReadKeyEnv("SMARTY_TEST_KEY"); err != nil || read != key {
+        t.Errorf("Environment variable read as %q: %v", read, err)
+    }
+    if _, err := smarty.ReadKeyEnv("SMARTY_TEST_UNSET"); !errors.Is(err, smarty.ErrInvalidKey) {
+        t.Errorf("Expected ErrInvalidKey for an unset variable, got %v", err)
+    }
+
+    stdin := strings.NewReader(key + "\r\nremaining input")
+    if read, err := smarty.ReadKey(stdin); err != nil || read != key {
+        t.Errorf("Stream read as %q: %v", read, err)
+    }
+    if remaining, _ := ioutil.ReadAll(stdin); string(remaining) != "remaining input" {
+        t.Errorf("Input following the key consumed, %q left", remaining)
+    }
+    if _, err := smarty.ReadKey(strings.NewReader("\n" + key)); !errors.Is(err, smarty.ErrInvalidKey) {
+        t.Errorf("Expected ErrInvalidKey for an empty line, got %v", err)
+    }
+}
+
+// Test if neither the redacted key nor the errors of invalid keys reveal the key
+func TestRedactKey(t *testing.T) {
+    if redacted := smarty.RedactKey(key); redacted != "D4...88" {
+        t.Errorf("Key redacted as %q", redacted)
+    }
+    if redacted := smarty.RedactKey("D491"); strings.Contains(redacted, "D4") {
+        t.Errorf("Short key redacted as %q", redacted)
+    }
+    if _, err := smarty.NewDecryptor("X491470F47126332B07D1923B3504188"); err == nil || strings.Contains(err.Error(),
+        "X") {
+        t.Errorf("Error of an invalid key reveals the key: %v", err)
+    }
+}
@@ -0,0 +1,251 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   The Keyring holds the decryption keys of several meters, eg. for a central receiver decrypting the telegrams
+   forwarded by many households. The key of a telegram is chosen by its system title. Keys are listed one per line
+   (or separated by commas), each preceded by the meter it belongs to, comments start with '#':
+       # system title (16 hex characters) or equipment identifier (OBIS 0-0:42.0.0)
+       534147677001bd54 D491470F47126332B07D1923B3504188
+       SAG1030700114004=D491470F47126332B07D1923B3504188
+   An equipment identifier matches the system title carrying the same manufacturer and serial number (its last
+   8 digits), see SystemTitle.go.
+*/
+package smarty
+
+import (
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// Number of trailing equipment identifier digits holding the serial number of the system title
+const equipmentSerialDigits = 8
+
+const manufacturerLetters = "ABCDEFGHIJKLMNOPQRSTUVWXYZ"
+
+// Struct selecting the Decryptor of a telegram by its system title
+// A Keyring is safe for concurrent use, also while it is reloaded.
+type Keyring struct {
+	config SecurityConfig
+	// Reads the keys for Reload, nil if the keys were added one by one
+	source func() ([]byte, error)
+
+	mutex sync.RWMutex
+	// Decryptors by system title
+	titles map[string]Decryptor
+	// Decryptors by manufacturer and serial number, as returned by SystemTitle.String
+	equipment map[string]Decryptor
+}
+
+// Creation of an empty Keyring, keys are added using Add
+// Parameter:
+// * config: the security settings shared by all meters, see DefaultSecurityConfig
+// Return:
+// * Keyring: a new object to execute methods on
+// * err: ErrInvalidSecurityConfig if the settings are not supported
+func NewKeyring(config SecurityConfig) (*Keyring, error) {
+	if _, err := newSecurity(config); err != nil {
+		return nil, err
+	}
+	return &Keyring{
+		config:    config,
+		titles:    make(map[string]Decryptor),
+		equipment: make(map[string]Decryptor),
+	}, nil
+}
+
+// Creation of a new Keyring from a key file, Reload reads the file again
+// Parameter:
+// * path: the key file, which must not be accessible by group or other users (eg. chmod 600)
+// * config: the security settings shared by all meters, see DefaultSecurityConfig
+// Return:
+// * Keyring: a new object to execute methods on
+// * err: the error of the file, ErrInvalidKey if an entry is malformed or ErrInvalidSecurityConfig
+func LoadKeyringFile(path string, config SecurityConfig) (*Keyring, error) {
+	return loadKeyring(config, func() ([]byte, error) {
+		file, err := openKeyFile(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		return ioutil.ReadAll(file)
+	})
+}
+
+// Creation of a new Keyring from an environment variable, Reload reads the variable again
+// Parameter:
+// * name: the name of the environment variable, eg. "SMARTY_KEYS"
+// * config: the security settings shared by all meters, see DefaultSecurityConfig
+// Return:
+// * Keyring: a new object to execute methods on
+// * err: ErrInvalidKey if the variable is not set or an entry is malformed, or ErrInvalidSecurityConfig
+func LoadKeyringEnv(name string, config SecurityConfig) (*Keyring, error) {
+	return loadKeyring(config, func() ([]byte, error) {
+		keys, found := os.LookupEnv(name)
+		if !found {
+			return nil, fmt.Errorf("%w: environment variable %s not set", ErrInvalidKey, name)
+		}
+		return []byte(keys), nil
+	})
+}
+
+func loadKeyring(config SecurityConfig, source func() ([]byte, error)) (*Keyring, error) {
+	keyring, err := NewKeyring(config)
+	if err != nil {
+		return nil, err
+	}
+	keyring.source = source
+	if err = keyring.Reload(); err != nil {
+		return nil, err
+	}
+	return keyring, nil
+}
+
+// Reads the keys again from the file or environment variable the Keyring was loaded from, eg. on SIGHUP
+// The keys are replaced as a whole, if reading fails the previous keys are kept.
+// Return:
+// * err: the same errors as LoadKeyringFile and LoadKeyringEnv, nil for keyrings not loaded from a source
+func (k *Keyring) Reload() error {
+	if k.source == nil {
+		return nil
+	}
+	keys, err := k.source()
+	if err != nil {
+		return err
+	}
+	reloaded, err := NewKeyring(k.config)
+	if err != nil {
+		return err
+	}
+	if err = reloaded.parse(keys); err != nil {
+		return err
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	k.titles, k.equipment = reloaded.titles, reloaded.equipment
+	return nil
+}
+
+// Adds the key of a meter, replacing its previous key
+// Parameter:
+// * meter: the system title (16 hex characters) or equipment identifier of the meter
+// * decryptionKey: the key of the meter
+// Return:
+// * err: ErrInvalidKey if the meter or key is malformed
+func (k *Keyring) Add(meter, decryptionKey string) error {
+	decryptor, err := NewDecryptorWithConfig(decryptionKey, k.config)
+	if err != nil {
+		return fmt.Errorf("%w (meter %s)", err, meter)
+	}
+
+	k.mutex.Lock()
+	defer k.mutex.Unlock()
+	if title, err := hex.DecodeString(meter); err == nil && len(title) == systemTitleLength {
+		k.titles[string(title)] = decryptor
+		return nil
+	}
+	equipment, err := equipmentName(meter)
+	if err != nil {
+		return err
+	}
+	k.equipment[equipment] = decryptor
+	return nil
+}
+
+// Returns the number of meters with a key
+func (k *Keyring) Len() int {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	return len(k.titles) + len(k.equipment)
+}
+
+// Returns the Decryptor of a meter
+// Parameter:
+// * title: the system title of the meter, as sent in every telegram
+// Return:
+// * decryptor: the Decryptor holding the key of the meter
+// * err: ErrUnknownMeter if the keyring holds no key for the meter
+func (k *Keyring) Decryptor(title SystemTitle) (decryptor Decryptor, err error) {
+	k.mutex.RLock()
+	defer k.mutex.RUnlock()
+	if decryptor, found := k.titles[string(title)]; found {
+		return decryptor, nil
+	}
+	if decryptor, found := k.equipment[title.String()]; title.valid() && found {
+		return decryptor, nil
+	}
+	return Decryptor{}, fmt.Errorf("%w: %s", ErrUnknownMeter, title)
+}
+
+// Decrypt a telegram using the key of the meter which sent it
+// Parameter:
+// * frame: the tokens of the telegram
+// Return:
+// * plaintText: the decrypted text, see Decryptor.DecryptFrame
+// * err: ErrUnknownMeter if the keyring holds no key for the system title, otherwise the errors of
+//      Decryptor.DecryptFrame
+func (k *Keyring) DecryptFrame(frame Frame) (plainText []byte, err error) {
+	decryptor, err := k.Decryptor(frame.SystemTitle)
+	if err != nil {
+		return nil, err
+	}
+	return decryptor.DecryptFrame(frame)
+}
+
+// Adds every entry of a key file
+func (k *Keyring) parse(keys []byte) error {
+	for number, line := range strings.Split(string(keys), "\n") {
+		if comment := strings.IndexByte(line, '#'); comment >= 0 {
+			line = line[:comment]
+		}
+		for _, entry := range strings.Split(line, ",") {
+			fields := strings.FieldsFunc(entry, func(r rune) bool { return r == '=' || unicode.IsSpace(r) })
+			switch len(fields) {
+			case 0:
+				continue
+			case 2:
+				if err := k.Add(fields[0], fields[1]); err != nil {
+					return fmt.Errorf("%w, line %d", err, number+1)
+				}
+			default:
+				return fmt.Errorf("%w: line %d does not hold a meter and a key", ErrInvalidKey, number+1)
+			}
+		}
+	}
+	return nil
+}
+
+// Converts an equipment identifier into the representation of the matching system title, eg. "SAG-114004"
+func equipmentName(equipment string) (string, error) {
+	if len(equipment) <= 3+equipmentSerialDigits || strings.TrimLeft(equipment[:3], manufacturerLetters) != "" {
+		return "", fmt.Errorf("%w: %q is neither a system title nor an equipment identifier", ErrInvalidKey,
+			equipment)
+	}
+	serial, err := strconv.ParseUint(equipment[len(equipment)-equipmentSerialDigits:], 10, 28)
+	if err != nil {
+		return "", fmt.Errorf("%w: equipment identifier %q does not end with a serial number", ErrInvalidKey,
+			equipment)
+	}
+	return fmt.Sprintf("%s-%d", equipment[:3], serial), nil
+}
@@ -0,0 +1,134 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package smarty_test
+
+import (
+    "bytes"
+    "errors"
+    "io/ioutil"
+    "os"
+    "path/filepath"
+    "testing"
+
+    "github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+// Test if the key of the pre-recorded telegram is chosen by its system title or equipment identifier
+func TestKeyring(t *testing.T) {
+    frame, err := smarty.NewTelegramReader(bytes.NewReader(telegram[:])).ReadTelegram()
+    if err != nil {
+        t.Fatal(err)
+    }
+    otherKey := "000102030405060708090A0B0C0D0E0F"
+
+    for _, meter := range []string{"534147677001bd54", "SAG1030700114004"} {
+        keyring, err := smarty.NewKeyring(smarty.DefaultSecurityConfig())
+        if err != nil {
+            t.Fatal(err)
+        }
+        if err = keyring.Add("5341476770000001", otherKey); err != nil {
+            t.Fatal(err)
+        }
+        if err = keyring.Add(meter, key); err != nil {
+            t.Fatal(err)
+        }
+        if _, err = keyring.DecryptFrame(frame); err != nil {
+            t.Errorf("Telegram could not be decrypted with the key of %s: %s", meter, err)
+        }
+    }
+
+    keyring, err := smarty.NewKeyring(smarty.DefaultSecurityConfig())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err = keyring.DecryptFrame(frame); !errors.Is(err, smarty.ErrUnknownMeter) {
+        t.Errorf("Expected ErrUnknownMeter for an empty keyring, got %v", err)
+    }
+    for _, invalid := range [][2]string{{"534147677001bd54", "00"}, {"SAG", key}, {"SAG10307001140XY", key},
+        {"sag1030700114004", key}} {
+        if err = keyring.Add(invalid[0], invalid[1]); !errors.Is(err, smarty.ErrInvalidKey) {
+            t.Errorf("Expected ErrInvalidKey for %q, got %v", invalid, err)
+        }
+    }
+}
+
+// Test if a key file is loaded and reloaded, keeping the previous keys if the file became invalid
+func TestKeyringFile(t *testing.T) {
+    frame, err := smarty.NewTelegramReader(bytes.NewReader(telegram[:])).ReadTelegram()
+    if err != nil {
+        t.Fatal(err)
+    }
+    directory, err := ioutil.TempDir("", "keyring")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer os.RemoveAll(directory)
+    path := filepath.Join(directory, "keys")
+
+    write := func(keys string) {
+        if err := ioutil.WriteFile(path, []byte(keys), 0600); err != nil {
+            t.Fatal(err)
+        }
+    }
+    write("# meters of the first household\n\n5341476770000001 000102030405060708090A0B0C0D0E0F\n")
+    keyring, err := smarty.LoadKeyringFile(path, smarty.DefaultSecurityConfig())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err = keyring.DecryptFrame(frame); !errors.Is(err, smarty.ErrUnknownMeter) {
+        t.Errorf("Expected ErrUnknownMeter before reloading, got %v", err)
+    }
+
+    write("5341476770000001=000102030405060708090A0B0C0D0E0F, SAG1030700114004=" + key + " # smarty\n")
+    if err = keyring.Reload(); err != nil {
+        t.Fatal(err)
+    }
+    if _, err = keyring.DecryptFrame(frame); err != nil || keyring.Len() != 2 {
+        t.Errorf("Telegram could not be decrypted after reloading %d keys: %v", keyring.Len(), err)
+    }
+
+    write("SAG1030700114004\n")
+    if err = keyring.Reload(); !errors.Is(err, smarty.ErrInvalidKey) {
+        t.Errorf("Expected ErrInvalidKey for an entry without key, got %v", err)
+    }
+    if keyring.Len() != 2 {
+        t.Errorf("Expected the previous 2 keys to be kept, found %d keys", keyring.Len())
+    }
+}
+
+// Test if the keys are loaded from an environment variable
+func TestKeyringEnv(t *testing.T) {
+    frame, err := smarty.NewTelegramReader(bytes.NewReader(telegram[:])).ReadTelegram()
+    if err != nil {
+        t.Fatal(err)
+    }
+    os.Setenv("SMARTY_TEST_KEYS", "534147677001bd54="+key)
+    defer os.Unsetenv("SMARTY_TEST_KEYS")
+
+    keyring, err := smarty.LoadKeyringEnv("SMARTY_TEST_KEYS", smarty.DefaultSecurityConfig())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err = keyring.DecryptFrame(frame); err != nil {
+        t.Errorf("Telegram could not be decrypted: %s", err)
+    }
+    if _, err = smarty.LoadKeyringEnv("SMARTY_TEST_UNSET", smarty.DefaultSecurityConfig()); !errors.Is(err,
+        smarty.ErrInvalidKey) {
+        t.Errorf("Expected ErrInvalidKey for an unset variable, got %v", err)
+    }
+}
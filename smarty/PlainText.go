@@ -0,0 +1,142 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   Unencrypted DSMR meters (eg. in the Netherlands and Belgium) send the telegram as plain text, from the '/'
+   identification line up to the '!' line holding the checksum. This file splits such a byte stream into telegrams,
+   and selects between the encrypted and the plain text format.
+*/
+package smarty
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The format of the telegrams read by a TelegramReader
+type ReaderMode int
+
+const (
+	// Encrypted smarty telegrams, starting with 0xDB
+	ModeEncrypted ReaderMode = iota
+	// Unencrypted DSMR telegrams, starting with '/'
+	ModePlainText
+	// Detect the format from the first complete telegram, the stream sticks to it afterwards
+	ModeAuto
+)
+
+var readerModeNames = map[ReaderMode]string{
+	ModeEncrypted: "encrypted",
+	ModePlainText: "plaintext",
+	ModeAuto:      "auto",
+}
+
+func (rm ReaderMode) String() string {
+	if name, ok := readerModeNames[rm]; ok {
+		return name
+	}
+	return fmt.Sprintf("ReaderMode(%d)", int(rm))
+}
+
+// Parses the name of a ReaderMode as returned by String, eg. "auto"
+func ParseReaderMode(name string) (ReaderMode, error) {
+	for mode, modeName := range readerModeNames {
+		if strings.EqualFold(name, modeName) {
+			return mode, nil
+		}
+	}
+	return 0, fmt.Errorf("unknown reader mode %q, expected encrypted, plaintext or auto", name)
+}
+
+// Upper limit of a plain text telegram, DSMR 5 telegrams are below 2 kB
+const maxPlainTextLength = 8192
+
+// Struct holding the state machine which splits a plain text DSMR byte stream into telegrams.
+// Each device requires its own PlainTextParser, since a telegram may be split over several reads.
+type PlainTextParser struct {
+	state    State
+	text     []byte
+	complete []byte
+}
+
+// Creation of a new PlainTextParser
+// Return:
+// * PlainTextParser: a new parser waiting for the '/' of the next telegram
+func NewPlainTextParser() *PlainTextParser {
+	return &PlainTextParser{state: waitingForStartByte}
+}
+
+// Feeds a chunk of the byte stream to the state machine
+// Parameter:
+// * input: the raw bytes as read from the meter
+// Return:
+// * consumed: the number of bytes processed, the remaining bytes belong to the next telegram
+// * ready: true if a complete telegram has been read, it is available through Text
+// * err: ErrFraming if the current telegram was dropped, processing continues with the remaining bytes
+func (pp *PlainTextParser) Process(input []byte) (consumed int, ready bool, err error) {
+	return pp.processByteStream(input)
+}
+
+// Returns the last complete telegram, from the '/' up to and including the line feed following the checksum
+func (pp *PlainTextParser) Text() []byte {
+	return append([]byte{}, pp.complete...)
+}
+
+func (pp *PlainTextParser) processByteStream(input []byte) (consumed int, ready bool, err error) {
+	for consumed < len(input) {
+		rawInput := input[consumed]
+		switch pp.state {
+		case waitingForStartByte:
+			if rawInput == '/' {
+				pp.text = append(pp.text[:0], rawInput)
+				pp.state = readPlainText
+			}
+		case readPlainText, readChecksum:
+			// A start byte or a byte which is not text belongs to the next telegram, it is not consumed
+			if rawInput == '/' || rawInput == 0xDB || (rawInput > '~' || rawInput < ' ') && rawInput != '\r' &&
+				rawInput != '\n' {
+				pp.state = waitingForStartByte
+				return consumed, false, fmt.Errorf("%w: unexpected byte 0x%02X in plain text telegram, "+
+					"dropping telegram", ErrFraming, rawInput)
+			}
+			if len(pp.text) >= maxPlainTextLength {
+				pp.state = waitingForStartByte
+				return consumed, false, fmt.Errorf("%w: plain text telegram exceeds %d bytes, dropping telegram",
+					ErrFraming, maxPlainTextLength)
+			}
+			pp.text = append(pp.text, rawInput)
+			// The checksum follows the '!', up to the end of the line
+			if rawInput == '!' {
+				pp.state = readChecksum
+			} else if rawInput == '\n' && pp.state == readChecksum {
+				pp.state = waitingForStartByte
+				pp.complete = append(pp.complete[:0], pp.text...)
+				return consumed + 1, true, nil
+			}
+		}
+		consumed++
+	}
+	return consumed, false, nil
+}
+
+func (pp *PlainTextParser) waiting() bool {
+	return pp.state == waitingForStartByte
+}
+
+func (pp *PlainTextParser) frame() Frame {
+	return Frame{Unencrypted: pp.Text()}
+}
@@ -0,0 +1,97 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package smarty_test
+
+import (
+    "bytes"
+    "errors"
+    "io"
+    "testing"
+
+    "github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+// Shortened telegram of an unencrypted DSMR 5 meter
+var dsmrTelegram = []byte("/ISK5\\2M550T-1012\r\n\r\n" +
+    "1-3:0.2.8(50)\r\n" +
+    "0-0:1.0.0(190416152034S)\r\n" +
+    "1-0:1.8.1(000433.345*kWh)\r\n" +
+    "1-0:1.7.0(00.282*kW)\r\n" +
+    "!6F4A\r\n")
+
+// Test if the plain text telegrams of a stream are found, skipping the noise in front of them
+// and dropping the telegram interrupted by the next one.
+func TestPlainTextReader(t *testing.T) {
+    var stream bytes.Buffer
+    stream.Write([]byte{0x00, 0x42, 0xFF})
+    stream.Write(dsmrTelegram)
+    stream.Write(dsmrTelegram[:40])
+    stream.Write(dsmrTelegram)
+
+    reader := smarty.NewTelegramReaderWithMode(&stream, smarty.ModePlainText)
+    expected := []error{nil, smarty.ErrFraming, nil, io.EOF}
+    for i, expectedErr := range expected {
+        frame, err := reader.ReadTelegram()
+        if !errors.Is(err, expectedErr) {
+            t.Fatalf("Read %d: expected %v, got %v", i+1, expectedErr, err)
+        }
+        if err == nil && (frame.Encrypted() || !bytes.Equal(frame.Unencrypted, dsmrTelegram)) {
+            t.Errorf("Read %d: unexpected telegram \n%s\n", i+1, frame.Unencrypted)
+        }
+    }
+}
+
+// Test if ModeAuto detects the format of both streams, the cipher text of the encrypted telegrams contains
+// several '/' which must not be taken for a plain text telegram.
+func TestAutoDetection(t *testing.T) {
+    encrypted, err := smarty.NewOnlineDecryptorFromReader(bytes.NewReader(append(telegram[:], telegram[:]...)), key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    encrypted.SetMode(smarty.ModeAuto)
+    for i := 0; i < 2; i++ {
+        plainText, err := encrypted.GetTelegram()
+        if err != nil {
+            t.Fatalf("Encrypted telegram %d: %s", i+1, err)
+        }
+        if !bytes.HasPrefix(plainText, []byte("/Lux5")) {
+            t.Errorf("Unexpected plain text: \n%s\n", plainText)
+        }
+    }
+
+    unencrypted, err := smarty.NewOnlineDecryptorFromReader(bytes.NewReader(dsmrTelegram), key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    unencrypted.SetMode(smarty.ModeAuto)
+    plainText, err := unencrypted.GetTelegram()
+    if err != nil || !bytes.Equal(plainText, dsmrTelegram) {
+        t.Errorf("Unencrypted telegram not detected: %v \n%s\n", err, plainText)
+    }
+}
+
+// Test if unencrypted meters can be read without a key.
+func TestPlainTextReaderWithoutKey(t *testing.T) {
+    smartyObj := smarty.NewPlainTextReaderFromReader(bytes.NewReader(dsmrTelegram))
+    defer smartyObj.Disconnect()
+
+    plainText, err := smartyObj.GetTelegram()
+    if err != nil || !bytes.Equal(plainText, dsmrTelegram) {
+        t.Errorf("Unexpected telegram: %v \n%s\n", err, plainText)
+    }
+}
@@ -0,0 +1,120 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   The frame counter of a meter increases with every telegram and never repeats for the same key. The ReplayGuard
+   remembers the last frame counter per system title, which reveals replayed telegrams, counter rollbacks and
+   missed telegrams, eg. when decrypting telegrams forwarded over untrusted links.
+*/
+
+package smarty
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sync"
+)
+
+// Behaviour of the OnlineDecryptor for replayed telegrams or frame counter rollbacks
+type ReplayPolicy int
+
+const (
+	// Deliver the telegram with Telegram.Replayed set
+	ReplayFlag ReplayPolicy = iota
+	// Drop the telegram with ErrReplay
+	ReplayReject
+)
+
+// Struct remembering the last frame counter of every system title
+// A ReplayGuard is safe for concurrent use.
+type ReplayGuard struct {
+	mutex sync.Mutex
+	last  map[string]uint32
+}
+
+// Creation of a new ReplayGuard
+// Return:
+// * ReplayGuard: a new object without any known frame counter
+func NewReplayGuard() *ReplayGuard {
+	return &ReplayGuard{
+		last: make(map[string]uint32),
+	}
+}
+
+// Compares the frame counter of a telegram to the last accepted one of the same system title
+// Check does not remember the frame counter, call Accept once the telegram has been authenticated.
+// Parameter:
+// * frame: the telegram to check
+// Return:
+// * missed: the number of telegrams skipped since the last accepted one
+// * err: ErrReplay if the frame counter did not increase, ErrFraming if the frame counter is malformed
+func (rg *ReplayGuard) Check(frame Frame) (missed uint32, err error) {
+	return rg.check(frame, false)
+}
+
+// Remembers the frame counter of an authenticated telegram
+// Lower frame counters than the known one are ignored.
+// Parameter:
+// * frame: the authenticated telegram
+func (rg *ReplayGuard) Accept(frame Frame) {
+	rg.check(frame, true)
+}
+
+// Checks and remembers the frame counter of an authenticated telegram at once
+// Unlike Check followed by Accept, the same telegram passes only once if it is checked by several goroutines.
+// Parameter:
+// * frame: the authenticated telegram
+// Return:
+// * missed: the number of telegrams skipped since the last accepted one
+// * err: ErrReplay if the frame counter did not increase, ErrFraming if the frame counter is malformed
+func (rg *ReplayGuard) CheckAndAccept(frame Frame) (missed uint32, err error) {
+	return rg.check(frame, true)
+}
+
+func (rg *ReplayGuard) check(frame Frame, accept bool) (missed uint32, err error) {
+	counter, err := frame.Counter()
+	if err != nil {
+		return 0, err
+	}
+	rg.mutex.Lock()
+	defer rg.mutex.Unlock()
+	last, known := rg.last[string(frame.SystemTitle)]
+	if accept && (!known || counter > last) {
+		rg.last[string(frame.SystemTitle)] = counter
+	}
+
+	switch {
+	case !known:
+		return 0, nil
+	case counter == last:
+		return 0, fmt.Errorf("%w: frame counter %d repeated", ErrReplay, counter)
+	case counter < last:
+		return 0, fmt.Errorf("%w: frame counter rolled back from %d to %d", ErrReplay, last, counter)
+	}
+	return counter - last - 1, nil
+}
+
+// Returns the frame counter of the telegram as number
+// Return:
+// * counter: the frame counter
+// * err: ErrFraming if the frame counter is not 4 bytes long
+func (f Frame) Counter() (counter uint32, err error) {
+	if len(f.FrameCounter) != 4 {
+		return 0, fmt.Errorf("%w: frame counter of %v bytes, expected 4 bytes", ErrFraming, len(f.FrameCounter))
+	}
+	return binary.BigEndian.Uint32(f.FrameCounter), nil
+}
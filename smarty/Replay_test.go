@@ -0,0 +1,163 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package smarty_test
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "sync"
+    "sync/atomic"
+    "testing"
+
+    "github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+// Encodes one telegram per frame counter into a single stream
+func encodeCounters(t *testing.T, counters ...uint32) *bytes.Buffer {
+    encoder, err := smarty.NewEncoder(key, systemTitle)
+    if err != nil {
+        t.Fatal(err)
+    }
+    var stream bytes.Buffer
+    for _, counter := range counters {
+        encoded, err := encoder.Encode([]byte("/Lux5\r\n\r\n!\r\n"), counter)
+        if err != nil {
+            t.Fatal(err)
+        }
+        stream.Write(encoded)
+    }
+    return &stream
+}
+
+// Test if gaps are reported and replayed or rolled back frame counters are flagged
+func TestReplayFlag(t *testing.T) {
+    smartyObj, err := smarty.NewOnlineDecryptorFromReader(encodeCounters(t, 1, 2, 5, 5, 3, 6), key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    expected := []struct {
+        missed   uint32
+        replayed bool
+    }{{0, false}, {0, false}, {2, false}, {0, true}, {0, true}, {0, false}}
+
+    for i, expectation := range expected {
+        telegram, err := smartyObj.ReadTelegram(context.Background())
+        if err != nil {
+            t.Fatalf("Telegram %d: %s", i+1, err)
+        }
+        if telegram.MissedTelegrams != expectation.missed || telegram.Replayed != expectation.replayed {
+            t.Errorf("Telegram %d: expected %d missed and replayed %t, got %d and %t", i+1,
+                expectation.missed, expectation.replayed, telegram.MissedTelegrams, telegram.Replayed)
+        }
+    }
+}
+
+// Test if replayed telegrams are dropped with ReplayReject
+func TestReplayReject(t *testing.T) {
+    smartyObj, err := smarty.NewOnlineDecryptorFromReader(encodeCounters(t, 7, 7, 6, 8), key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    smartyObj.SetReplayPolicy(smarty.ReplayReject)
+
+    for i, replayed := range []bool{false, true, true, false} {
+        _, err := smartyObj.GetTelegram()
+        if replayed != errors.Is(err, smarty.ErrReplay) || (!replayed && err != nil) {
+            t.Errorf("Telegram %d: unexpected error %v", i+1, err)
+        }
+    }
+}
+
+// Test if the frame counter of a telegram without authentication does not lock out the authenticated ones
+func TestReplayUnauthenticated(t *testing.T) {
+    config := smarty.DefaultSecurityConfig()
+    config.SecurityControl = smarty.SecurityEncryption
+    encoder, err := smarty.NewEncoderWithConfig(key, systemTitle, config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    forged, err := encoder.Encode([]byte("/Lux5\r\n\r\n!\r\n"), 100)
+    if err != nil {
+        t.Fatal(err)
+    }
+    stream := encodeCounters(t, 5)
+    stream.Write(forged)
+    stream.Write(encodeCounters(t, 6).Bytes())
+
+    smartyObj, err := smarty.NewOnlineDecryptorFromReader(stream, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    config.SecurityControl = 0
+    if err = smartyObj.SetSecurityConfig(config); err != nil {
+        t.Fatal(err)
+    }
+    smartyObj.SetReplayPolicy(smarty.ReplayReject)
+
+    for i := 1; i <= 3; i++ {
+        if _, err := smartyObj.GetTelegram(); err != nil {
+            t.Errorf("Telegram %d: unexpected error %v", i, err)
+        }
+    }
+}
+
+// Test if only accepted frame counters are remembered, per system title
+func TestReplayGuard(t *testing.T) {
+    guard := smarty.NewReplayGuard()
+    first := smarty.Frame{SystemTitle: []byte("SAG00001"), FrameCounter: []byte{0, 0, 0, 10}}
+    other := smarty.Frame{SystemTitle: []byte("SAG00002"), FrameCounter: []byte{0, 0, 0, 1}}
+
+    guard.Accept(first)
+    if _, err := guard.Check(other); err != nil {
+        t.Errorf("Frame counters of different meters interfere: %s", err)
+    }
+    if _, err := guard.Check(first); !errors.Is(err, smarty.ErrReplay) {
+        t.Errorf("Expected ErrReplay, got %v", err)
+    }
+    later := smarty.Frame{SystemTitle: first.SystemTitle, FrameCounter: []byte{0, 0, 0, 20}}
+    if missed, err := guard.Check(later); err != nil || missed != 9 {
+        t.Errorf("Expected 9 missed telegrams, got %d (%v)", missed, err)
+    }
+    // Not accepted, the next check still refers to frame counter 10
+    if missed, _ := guard.Check(later); missed != 9 {
+        t.Errorf("Checked frame counter remembered without Accept")
+    }
+}
+
+// Test if a telegram checked by several goroutines at once passes only once
+func TestReplayGuardConcurrent(t *testing.T) {
+    guard := smarty.NewReplayGuard()
+    frame := smarty.Frame{SystemTitle: []byte("SAG00001"), FrameCounter: []byte{0, 0, 0, 10}}
+
+    var passed int32
+    var wg sync.WaitGroup
+    for i := 0; i < 50; i++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            if _, err := guard.CheckAndAccept(frame); err == nil {
+                atomic.AddInt32(&passed, 1)
+            }
+        }()
+    }
+    wg.Wait()
+    if passed != 1 {
+        t.Errorf("Telegram passed %d times", passed)
+    }
+}
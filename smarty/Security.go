@@ -0,0 +1,136 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   The smarty telegram is a DLMS general-glo-ciphering frame. Its security control byte selects whether the payload
+   is authenticated (0x10), encrypted (0x20) or both (0x30, used by the smarty). The authentication key is part of
+   the additional authenticated data and the length of the authentication tag differs between grid operators, both
+   are configured through a SecurityConfig.
+*/
+package smarty
+
+import (
+	"fmt"
+)
+
+// Bits of the security control byte
+const (
+	SecurityAuthentication          byte = 0x10
+	SecurityEncryption              byte = 0x20
+	SecurityAuthenticatedEncryption      = SecurityAuthentication | SecurityEncryption
+	// Compressed payloads are not supported
+	securityCompression byte = 0x80
+	// Security suite 0 and 1 both use AES-GCM-128
+	securitySuiteMask byte = 0x0F
+	maxSecuritySuite  byte = 1
+)
+
+// The authentication key used by the smarty
+const defaultAuthenticationKey = "00112233445566778899AABBCCDDEEFF"
+
+// Struct holding the DLMS security settings of a meter
+type SecurityConfig struct {
+	// The authentication key (AK), as 32 character hex string
+	AuthenticationKey string
+	// Length of the authentication tag in bytes, 12 (smarty) or 16
+	TagLength int
+	// The security control byte sent by the Encoder. The Decryptor only accepts telegrams with the same
+	// authentication and encryption bits, 0 accepts any supported combination.
+	SecurityControl byte
+}
+
+// Returns the settings of the smarty: authenticated encryption (0x30) with a 12 byte tag
+func DefaultSecurityConfig() SecurityConfig {
+	return SecurityConfig{
+		AuthenticationKey: defaultAuthenticationKey,
+		TagLength:         GCMTagLength,
+		SecurityControl:   SecurityAuthenticatedEncryption,
+	}
+}
+
+// Struct holding the decoded security settings, shared by the Decryptor and the Encoder
+type security struct {
+	authenticationKey []byte
+	tagLength         int
+	securityControl   byte
+	// Prepared additional authenticated data of the configured security control byte
+	aad []byte
+}
+
+func newSecurity(config SecurityConfig) (security, error) {
+	authenticationKey, err := decodeKey(config.AuthenticationKey)
+	if err != nil {
+		return security{}, fmt.Errorf("%w: authentication key: %v", ErrInvalidSecurityConfig, err)
+	}
+	if config.TagLength < 12 || config.TagLength > 16 {
+		return security{}, fmt.Errorf("%w: tag length of %d bytes, expected 12 to 16 bytes",
+			ErrInvalidSecurityConfig, config.TagLength)
+	}
+	if config.SecurityControl != 0 && !validSecurityControl(config.SecurityControl) {
+		return security{}, fmt.Errorf("%w: unsupported security control byte 0x%02X",
+			ErrInvalidSecurityConfig, config.SecurityControl)
+	}
+	settings := security{
+		authenticationKey: authenticationKey,
+		tagLength:         config.TagLength,
+		securityControl:   config.SecurityControl,
+	}
+	if settings.securityControl != 0 {
+		settings.aad = settings.additionalData(settings.securityControl)
+	} else {
+		settings.aad = settings.additionalData(SecurityAuthenticatedEncryption)
+	}
+	return settings, nil
+}
+
+// Returns the additional authenticated data: security control byte + authentication key
+// The returned slice must not be modified.
+func (s security) additionalData(securityControl byte) []byte {
+	if len(s.aad) > 0 && s.aad[0] == securityControl {
+		return s.aad
+	}
+	return append([]byte{securityControl}, s.authenticationKey...)
+}
+
+// Returns an error if the telegram does not use the expected security control byte
+func (s security) accepts(securityControl byte) error {
+	const mode = SecurityAuthenticatedEncryption
+	if s.securityControl != 0 && securityControl&mode != s.securityControl&mode {
+		return fmt.Errorf("%w: security control byte 0x%02X, expected 0x%02X",
+			ErrAuthFailed, securityControl, s.securityControl)
+	}
+	return nil
+}
+
+// Reports whether the security control byte describes a supported security mode
+func validSecurityControl(securityControl byte) bool {
+	return securityControl&SecurityAuthenticatedEncryption != 0 && securityControl&securityCompression == 0 &&
+		securityControl&securitySuiteMask <= maxSecuritySuite
+}
+
+// Returns the length of the authentication tag following the payload, telegrams without authentication have none
+func tagLengthOf(securityControl byte, tagLength int) int {
+	if securityControl&SecurityAuthentication == 0 {
+		return 0
+	}
+	return tagLength
+}
+
+// Returns the initial counter block of the payload encryption, which GCM starts at 2
+func counterBlock(initialValue []byte) []byte {
+	return append(append([]byte{}, initialValue...), 0, 0, 0, 2)
+}
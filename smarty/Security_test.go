@@ -0,0 +1,132 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package smarty_test
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+
+    "github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+var otherAuthenticationKey = "F0E1D2C3B4A5968778695A4B3C2D1E0F"
+
+// Test if telegrams of every security mode, with a 16 byte tag and another authentication key,
+// pass through the OnlineDecryptor.
+func TestSecurityModes(t *testing.T) {
+    otherKey := "000102030405060708090A0B0C0D0E0F"
+    plainText := []byte("/Lux5\\253663629_D\r\n\r\n1-0:1.8.0(000123.456*kWh)\r\n!\r\n")
+
+    for _, securityControl := range []byte{0x10, 0x20, 0x30} {
+        config := smarty.SecurityConfig{
+            AuthenticationKey: otherAuthenticationKey,
+            TagLength:         16,
+            SecurityControl:   securityControl,
+        }
+        encoder, err := smarty.NewEncoderWithConfig(otherKey, []byte("XYZ12345"), config)
+        if err != nil {
+            t.Fatal(err)
+        }
+        encoded, err := encoder.Encode(plainText, 1)
+        if err != nil {
+            t.Fatal(err)
+        }
+
+        smartyObj, err := smarty.NewOnlineDecryptorFromReader(bytes.NewReader(encoded), otherKey)
+        if err != nil {
+            t.Fatal(err)
+        }
+        config.SecurityControl = 0
+        if err = smartyObj.SetSecurityConfig(config); err != nil {
+            t.Fatal(err)
+        }
+        telegram, err := smartyObj.GetTelegram()
+        if err != nil {
+            t.Fatalf("Security control 0x%02X: %s", securityControl, err)
+        }
+        if !bytes.Equal(telegram, plainText) {
+            t.Errorf("Security control 0x%02X: unexpected plain text %q", securityControl, telegram)
+        }
+    }
+}
+
+// Test if modified telegrams without encryption and telegrams with an unexpected security control byte are rejected
+func TestSecurityErrors(t *testing.T) {
+    config := smarty.DefaultSecurityConfig()
+    config.SecurityControl = 0x10
+    encoder, err := smarty.NewEncoderWithConfig(key, []byte("XYZ12345"), config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    frame, err := encoder.EncryptFrame([]byte("/Lux5\r\n\r\n!\r\n"), 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    decryptor, err := smarty.NewDecryptorWithConfig(key, config)
+    if err != nil {
+        t.Fatal(err)
+    }
+    frame.Payload[1] = 'X'
+    if _, err = decryptor.DecryptFrame(frame); !errors.Is(err, smarty.ErrAuthFailed) {
+        t.Errorf("Expected ErrAuthFailed for a modified payload, got %v", err)
+    }
+
+    // The smarty settings only accept authenticated encryption
+    decryptor, err = smarty.NewDecryptor(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    frame.SecurityControl = 0x20
+    if _, err = decryptor.DecryptFrame(frame); !errors.Is(err, smarty.ErrAuthFailed) {
+        t.Errorf("Expected ErrAuthFailed for an unexpected security control byte, got %v", err)
+    }
+
+    for _, invalid := range []smarty.SecurityConfig{
+        {AuthenticationKey: "00", TagLength: 12},
+        {AuthenticationKey: otherAuthenticationKey, TagLength: 8},
+        {AuthenticationKey: otherAuthenticationKey, TagLength: 12, SecurityControl: 0x80},
+    } {
+        if _, err = smarty.NewDecryptorWithConfig(key, invalid); !errors.Is(err, smarty.ErrInvalidSecurityConfig) {
+            t.Errorf("Expected ErrInvalidSecurityConfig for %+v, got %v", invalid, err)
+        }
+    }
+}
+
+// Test if a short telegram with a single length byte instead of the 0x82 prefix is recognised
+func TestShortLengthFraming(t *testing.T) {
+    encoder, err := smarty.NewEncoder(key, systemTitle)
+    if err != nil {
+        t.Fatal(err)
+    }
+    encoded, err := encoder.Encode([]byte("/Lux5\r\n\r\n!\r\n"), 1)
+    if err != nil {
+        t.Fatal(err)
+    }
+    // Replace separator 0x82 and the two length bytes by the length
+    short := append(append(append([]byte{}, encoded[:10]...), encoded[12]), encoded[13:]...)
+
+    smartyObj, err := smarty.NewOnlineDecryptorFromReader(bytes.NewReader(short), key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err = smartyObj.GetTelegram(); err != nil {
+        t.Error(err)
+    }
+}
@@ -0,0 +1,216 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   The serial line settings of the P1 port. The smarty and DSMR 4/5 meters send with 115200 baud 8N1, older DSMR
+   meters with 9600 baud 7E1. If the settings are unknown, the port can be probed with the common settings until
+   the start of a telegram is recognised.
+*/
+package smarty
+
+import (
+	"fmt"
+	"io"
+	"regexp"
+	"time"
+
+	"github.com/golang/glog"
+	"github.com/tarm/serial"
+)
+
+// Parity bit setting of the serial line
+type Parity byte
+
+const (
+	ParityNone Parity = 'N'
+	ParityOdd  Parity = 'O'
+	ParityEven Parity = 'E'
+)
+
+// Struct holding the serial line settings
+type SerialConfig struct {
+	// The port to listen to, eg. /dev/ttyUSB0 or COM8
+	Device   string
+	Baud     int
+	DataBits byte
+	Parity   Parity
+	StopBits byte
+	// Maximum time a single read blocks, the port is checked for Disconnect at this interval
+	ReadTimeout time.Duration
+	// Hint that the adapter passes the P1 signal without inverting it. The serial driver is not able to invert
+	// the signal, the hint only extends the error message if no telegram could be recognised while probing.
+	NonInvertedSignal bool
+	// Probe the common settings (see ProbeSettings) until a telegram is recognised, instead of using the above
+	AutoProbe bool
+	// Time to wait for a telegram per probed setting, the smarty sends a telegram every 10 seconds
+	// 0 waits for the default of 11 seconds.
+	ProbeTimeout time.Duration
+}
+
+// Default time to wait for a telegram per probed setting, slightly longer than the telegram interval
+const defaultProbeTimeout = 11 * time.Second
+
+// Returns the settings of the smarty: 115200 baud 8N1
+// Parameter:
+// * device: the port to listen to
+func DefaultSerialConfig(device string) SerialConfig {
+	return SerialConfig{
+		Device:       device,
+		Baud:         115200,
+		DataBits:     8,
+		Parity:       ParityNone,
+		StopBits:     1,
+		ReadTimeout:  serialPollInterval,
+		ProbeTimeout: defaultProbeTimeout,
+	}
+}
+
+// The settings tried while probing, most common first
+var ProbeSettings = []SerialConfig{
+	{Baud: 115200, DataBits: 8, Parity: ParityNone, StopBits: 1},
+	{Baud: 9600, DataBits: 7, Parity: ParityEven, StopBits: 1},
+	{Baud: 115200, DataBits: 7, Parity: ParityEven, StopBits: 1},
+	{Baud: 9600, DataBits: 8, Parity: ParityNone, StopBits: 1},
+}
+
+// Returns the line settings in the common notation, eg. "115200 8N1"
+func (sc SerialConfig) String() string {
+	return fmt.Sprintf("%d %d%c%d", sc.Baud, sc.DataBits, sc.Parity, sc.StopBits)
+}
+
+// Parses the line settings in the common notation, eg. "8N1" or "7E1"
+// Parameter:
+// * format: data bits, parity (N, O or E) and stop bits
+// Return:
+// * dataBits, parity, stopBits: the parsed settings
+// * err: ErrInvalidSerialConfig if the format is not supported
+func ParseSerialFormat(format string) (dataBits byte, parity Parity, stopBits byte, err error) {
+	if len(format) != 3 || format[0] < '5' || format[0] > '8' || (format[2] != '1' && format[2] != '2') {
+		return 0, 0, 0, fmt.Errorf("%w: format %q", ErrInvalidSerialConfig, format)
+	}
+	parity = Parity(format[1])
+	switch parity {
+	case ParityNone, ParityOdd, ParityEven:
+	default:
+		return 0, 0, 0, fmt.Errorf("%w: parity %q", ErrInvalidSerialConfig, format[1])
+	}
+	return format[0] - '0', parity, format[2] - '0', nil
+}
+
+func (sc SerialConfig) serialConfig() *serial.Config {
+	readTimeout := sc.ReadTimeout
+	if readTimeout <= 0 {
+		readTimeout = serialPollInterval
+	}
+	return &serial.Config{
+		Name:        sc.Device,
+		Baud:        sc.Baud,
+		Size:        sc.DataBits,
+		Parity:      serial.Parity(sc.Parity),
+		StopBits:    serial.StopBits(sc.StopBits),
+		ReadTimeout: readTimeout,
+	}
+}
+
+// Start of a plain text telegram: '/' followed by the manufacturer code and the baud rate identification
+var plainTextStart = regexp.MustCompile(`/[A-Za-z]{3}[0-9]`)
+
+// Opens the port with every setting of ProbeSettings, until a telegram start is recognised
+// Parameter:
+// * config: the device, read timeout and probe timeout to use, the line settings are ignored
+// Return:
+// * detected: config with the line settings which received a telegram start
+// * err: ErrDeviceUnavailable if the port could not be opened or read, or no telegram was recognised
+func ProbeSerialConfig(config SerialConfig) (detected SerialConfig, err error) {
+	for _, setting := range ProbeSettings {
+		candidate := config
+		candidate.Baud = setting.Baud
+		candidate.DataBits = setting.DataBits
+		candidate.Parity = setting.Parity
+		candidate.StopBits = setting.StopBits
+		candidate.AutoProbe = false
+
+		glog.Infof("Probing %s with %s\n", config.Device, candidate)
+		recognised, err := probe(candidate)
+		if err != nil {
+			return SerialConfig{}, err
+		}
+		if recognised {
+			glog.Infof("Telegram recognised with %s\n", candidate)
+			return candidate, nil
+		}
+	}
+
+	err = fmt.Errorf("%w: %s: no telegram recognised with any probed setting", ErrDeviceUnavailable, config.Device)
+	if config.NonInvertedSignal {
+		err = fmt.Errorf("%w, the P1 signal has to be inverted by the cable or adapter", err)
+	}
+	return SerialConfig{}, err
+}
+
+func probe(config SerialConfig) (recognised bool, err error) {
+	port, err := serial.OpenPort(config.serialConfig())
+	if err != nil {
+		return false, fmt.Errorf("%w: %s: %v", ErrDeviceUnavailable, config.Device, err)
+	}
+	defer port.Close()
+
+	received := make([]byte, 0, 4096)
+	buffer := make([]byte, 512)
+	probeTimeout := config.ProbeTimeout
+	if probeTimeout <= 0 {
+		probeTimeout = defaultProbeTimeout
+	}
+	deadline := time.Now().Add(probeTimeout)
+	for time.Now().Before(deadline) {
+		n, err := port.Read(buffer)
+		// An elapsed read timeout returns without data, either with or without io.EOF depending on the platform
+		if err != nil && err != io.EOF {
+			return false, fmt.Errorf("%w: %s: %v", ErrDeviceUnavailable, config.Device, err)
+		}
+		received = append(received, buffer[:n]...)
+		if looksLikeTelegram(received) {
+			return true, nil
+		}
+		// Keep the end of the received data, a telegram start may be split over two reads
+		if len(received) > 2048 {
+			received = append(received[:0], received[len(received)-64:]...)
+		}
+	}
+	return false, nil
+}
+
+// Reports whether the data contains the start of an encrypted or plain text telegram
+func looksLikeTelegram(data []byte) bool {
+	if plainTextStart.Match(data) {
+		return true
+	}
+	// Both separators of an encrypted telegram have to be at the expected positions
+	parser := NewFrameParser()
+	for _, rawInput := range data {
+		parser.currentBytePosition++
+		parser.processStateActions(rawInput)
+		// Telegrams are longer than 127 bytes, a length without 0x81 / 0x82 prefix is taken for noise
+		if parser.state >= readFrameCounter {
+			if parser.dataLength >= 0x80 {
+				return true
+			}
+			parser.state = waitingForStartByte
+		}
+	}
+	return false
+}
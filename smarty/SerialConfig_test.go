@@ -0,0 +1,47 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package smarty_test
+
+import (
+    "errors"
+    "testing"
+
+    "github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+// Test if the common notations of the line settings are parsed and printed
+func TestSerialFormat(t *testing.T) {
+    config := smarty.DefaultSerialConfig("/dev/ttyUSB0")
+    if config.String() != "115200 8N1" {
+        t.Errorf("Unexpected default settings %s", config)
+    }
+
+    dataBits, parity, stopBits, err := smarty.ParseSerialFormat("7E1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if dataBits != 7 || parity != smarty.ParityEven || stopBits != 1 {
+        t.Errorf("Unexpected settings %d%c%d", dataBits, parity, stopBits)
+    }
+
+    for _, format := range []string{"", "8N", "9N1", "8X1", "8N3", "8N1 "} {
+        if _, _, _, err := smarty.ParseSerialFormat(format); !errors.Is(err, smarty.ErrInvalidSerialConfig) {
+            t.Errorf("Format %q: expected ErrInvalidSerialConfig, got %v", format, err)
+        }
+    }
+}
@@ -16,13 +16,16 @@
  */
 
 /*
-   This file handles reading the smarty telegrams over a serial connection, and splitting in its different tokens
-   using a state machine.
+   This file handles opening the serial connection to the smarty, and splitting the telegrams in their different
+   tokens using a state machine.
 */
 package smarty
 
 import (
-	"bufio"
+	"fmt"
+	"io"
+	"sync/atomic"
+	"time"
 
 	"github.com/golang/glog"
 	"github.com/tarm/serial"
@@ -30,6 +33,15 @@ import (
 
 const GCMTagLength = 12
 
+// The system title and frame counter form the 12 byte initial value
+const systemTitleLength = 8
+
+// Shortest length of a frame: security control byte, frame counter and one payload byte (encryption only, no tag)
+const minimumFrameLength = 6
+
+// Default maximum time a read on the serial port blocks before checking if the port has been closed
+const serialPollInterval = 500 * time.Millisecond
+
 type State int
 
 const (
@@ -38,17 +50,14 @@ const (
 	readSystemTitle
 	readSeparator82
 	readPayloadLength
-	readSeparator30
+	readSecurityControl
 	readFrameCounter
 	readPayload
 	readGcmTag
 	doneReadingTelegram
-)
-
-var (
-	state                                                = waitingForStartByte
-	currentBytePosition, changeToNextStateAt, dataLength int
-	systemTitle, frameCounter, dataPayload, gcmTag       []byte
+	// States of the PlainTextParser
+	readPlainText
+	readChecksum
 )
 
 type Smarty interface {
@@ -57,138 +66,304 @@ type Smarty interface {
 
 type deviceInfo struct {
 	deviceName string
-	reader     *bufio.Reader
-	port       *serial.Port
+	reader     *TelegramReader
+	port       io.Closer
+	input      *capturingReader
+}
+
+func newSerialDeviceInfo(config SerialConfig) (deviceInfo, error) {
+	if config.AutoProbe {
+		detected, err := ProbeSerialConfig(config)
+		if err != nil {
+			return deviceInfo{}, err
+		}
+		config = detected
+	}
+	port, err := openSerialConnection(config)
+	if err != nil {
+		return deviceInfo{}, err
+	}
+	glog.Infof("Serial connection established (%s)\n", config)
+	input := &capturingReader{input: port}
+	return deviceInfo{
+		deviceName: config.Device,
+		reader:     NewTelegramReader(input),
+		port:       port,
+		input:      input,
+	}, nil
+}
+
+func newStreamDeviceInfo(stream io.Reader) deviceInfo {
+	// Streams which can be closed are closed on Disconnect
+	port, _ := stream.(io.Closer)
+	input := &capturingReader{input: stream}
+	return deviceInfo{
+		reader: NewTelegramReader(input),
+		port:   port,
+		input:  input,
+	}
+}
+
+func (di *deviceInfo) setCapture(capture *CaptureWriter) {
+	di.input.capture.Store(capture)
+}
+
+func (di *deviceInfo) disconnect() {
+	if di.port == nil {
+		return
+	}
+	err := di.port.Close()
+	if err == nil {
+		glog.Infoln("Serial connection closed")
+	} else {
+		glog.Errorln("Unable to close serial connection")
+	}
+}
+
+// Struct holding the state machine which splits a smarty byte stream into its tokens.
+// Each device requires its own FrameParser, since a telegram may be split over several reads.
+type FrameParser struct {
+	state                                                State
+	currentBytePosition, changeToNextStateAt, dataLength int
+	securityControl                                      byte
+	systemTitle, frameCounter, dataPayload, gcmTag       []byte
+	tagLength                                            int
+}
+
+// Creation of a new FrameParser
+// Return:
+// * FrameParser: a new parser waiting for the start byte of the next telegram
+func NewFrameParser() *FrameParser {
+	fp := &FrameParser{tagLength: GCMTagLength}
+	fp.resetVariables()
+	return fp
+}
+
+// Sets the length of the authentication tag, do not call while a telegram is being parsed
+// Parameter:
+// * tagLength: 12 (smarty, default) or 16 bytes, telegrams without authentication (0x20) carry no tag
+func (fp *FrameParser) SetTagLength(tagLength int) {
+	fp.tagLength = tagLength
+}
+
+// Feeds a chunk of the byte stream to the state machine
+// Parameter:
+// * input: the raw bytes as read from the smarty
+// Return:
+// * consumed: the number of bytes processed, the remaining bytes belong to the next telegram
+// * ready: true if a complete telegram has been read, its tokens are available through CipherComponents
+// * err: ErrFraming if the current telegram was dropped, processing continues with the remaining bytes
+func (fp *FrameParser) Process(input []byte) (consumed int, ready bool, err error) {
+	return fp.processByteStream(input)
+}
+
+// Returns the tokens of the last complete telegram
+// Return:
+// * iv: the initial value (system title + frame counter)
+// * cipherText: the payload with appended gcm tag
+func (fp *FrameParser) CipherComponents() (iv, cipherText []byte) {
+	return fp.prepareCipherComponents()
 }
 
-func processByteStream(input []byte, length int) (ready bool) {
-	ready = false
-	for i := 0; i < length && !ready; i++ {
+func (fp *FrameParser) processByteStream(input []byte) (consumed int, ready bool, err error) {
+	for consumed < len(input) && !ready && err == nil {
 		// Keep track of the position in the byte stream
-		currentBytePosition++
+		fp.currentBytePosition++
 
-		// Run the appropriate actions (nil if telegram not yet complete)
-		ready = processStateActions(input[i])
+		// Run the appropriate actions (false if telegram not yet complete)
+		ready, err = fp.processStateActions(input[consumed])
+		consumed++
 	}
 	return
-
 }
 
-func resetVariables() {
-	state = waitingForStartByte
-	currentBytePosition = 0
-	changeToNextStateAt = 0
-	systemTitle = []byte("")
-	dataLength = 0
-	frameCounter = []byte("")
-	dataPayload = []byte("")
-	gcmTag = []byte("")
+func (fp *FrameParser) resetVariables() {
+	fp.state = waitingForStartByte
+	fp.currentBytePosition = 0
+	fp.changeToNextStateAt = 0
+	fp.systemTitle = []byte("")
+	fp.dataLength = 0
+	fp.securityControl = 0
+	fp.frameCounter = []byte("")
+	fp.dataPayload = []byte("")
+	fp.gcmTag = []byte("")
 }
 
-func processStateActions(rawInput byte) (ready bool) {
-	switch state {
+func (fp *FrameParser) processStateActions(rawInput byte) (ready bool, err error) {
+	switch fp.state {
 	case waitingForStartByte:
 		if rawInput == 0xDB {
-			resetVariables()
-			state = readSystemTitleLength
+			fp.resetVariables()
+			fp.state = readSystemTitleLength
 		}
 	case readSystemTitleLength:
-		state = readSystemTitle
+		fp.state = readSystemTitle
 		// 2 start bytes (position 0 and 1) + system title length
-		changeToNextStateAt = 1 + int(rawInput)
+		fp.changeToNextStateAt = 1 + int(rawInput)
 	case readSystemTitle:
-		systemTitle = append(systemTitle, rawInput)
-		if currentBytePosition >= changeToNextStateAt {
-			state = readSeparator82
-			changeToNextStateAt++
+		fp.systemTitle = append(fp.systemTitle, rawInput)
+		if fp.currentBytePosition >= fp.changeToNextStateAt {
+			fp.state = readSeparator82
+			fp.changeToNextStateAt++
 		}
 	case readSeparator82:
-		if rawInput == 0x82 {
-			state = readPayloadLength // Ignore separator byte
-			changeToNextStateAt += 2
-		} else {
-			glog.Errorln("Missing separator (0x82). Dropping telegram.")
-			state = waitingForStartByte
+		// The smarty always sends the length in 2 bytes (0x82), other meters may use 1 byte (0x81)
+		// A-XDR allows lengths below 0x80 without prefix, as long as they can hold a frame
+		switch {
+		case rawInput == 0x82 || rawInput == 0x81:
+			fp.state = readPayloadLength // Ignore separator byte
+			fp.changeToNextStateAt += int(rawInput & 0x0F)
+		case rawInput < 0x80 && rawInput >= minimumFrameLength:
+			fp.dataLength = int(rawInput)
+			fp.state = readSecurityControl
+			fp.changeToNextStateAt++
+		default:
+			fp.state = waitingForStartByte
+			return false, fmt.Errorf("%w: missing separator (0x82), dropping telegram", ErrFraming)
 		}
 	case readPayloadLength:
-		dataLength <<= 8
-		dataLength |= int(rawInput)
-		if currentBytePosition >= changeToNextStateAt {
-			state = readSeparator30
-			changeToNextStateAt++
+		fp.dataLength <<= 8
+		fp.dataLength |= int(rawInput)
+		if fp.currentBytePosition >= fp.changeToNextStateAt {
+			fp.state = readSecurityControl
+			fp.changeToNextStateAt++
+		}
+	case readSecurityControl:
+		if !validSecurityControl(rawInput) {
+			fp.state = waitingForStartByte
+			return false, fmt.Errorf("%w: unsupported security control byte (0x%02X), dropping telegram",
+				ErrFraming, rawInput)
 		}
-	case readSeparator30:
-		if rawInput == 0x30 {
-			state = readFrameCounter
-			// 4 bytes for frame counter
-			changeToNextStateAt += 4
-		} else {
-			glog.Errorln("Missing separator (0x30). Dropping telegram.")
-			state = waitingForStartByte
+		// Security control byte and frame counter precede the payload, the tag follows it
+		if fp.dataLength-5-tagLengthOf(rawInput, fp.tagLength) <= 0 {
+			fp.state = waitingForStartByte
+			return false, fmt.Errorf("%w: length of %d bytes leaves no payload, dropping telegram",
+				ErrFraming, fp.dataLength)
 		}
+		fp.securityControl = rawInput
+		fp.state = readFrameCounter
+		// 4 bytes for frame counter
+		fp.changeToNextStateAt += 4
 	case readFrameCounter:
-		frameCounter = append(frameCounter, rawInput)
-		if currentBytePosition >= changeToNextStateAt {
-			state = readPayload
-			changeToNextStateAt += dataLength - 17
+		fp.frameCounter = append(fp.frameCounter, rawInput)
+		if fp.currentBytePosition >= fp.changeToNextStateAt {
+			fp.state = readPayload
+			fp.changeToNextStateAt += fp.dataLength - 5 - tagLengthOf(fp.securityControl, fp.tagLength)
 		}
 	case readPayload:
-		dataPayload = append(dataPayload, rawInput)
-		if currentBytePosition >= changeToNextStateAt {
-			state = readGcmTag
-			changeToNextStateAt += GCMTagLength
+		fp.dataPayload = append(fp.dataPayload, rawInput)
+		if fp.currentBytePosition >= fp.changeToNextStateAt {
+			fp.state = readGcmTag
+			fp.changeToNextStateAt += tagLengthOf(fp.securityControl, fp.tagLength)
+			if fp.securityControl&SecurityAuthentication == 0 {
+				// Encryption only, no tag follows
+				fp.state = doneReadingTelegram
+			}
 		}
 	case readGcmTag:
 		// All input has been read.
-		gcmTag = append(gcmTag, rawInput)
-		if currentBytePosition >= changeToNextStateAt {
-			state = doneReadingTelegram
+		fp.gcmTag = append(fp.gcmTag, rawInput)
+		if fp.currentBytePosition >= fp.changeToNextStateAt {
+			fp.state = doneReadingTelegram
 		}
 	}
-	if state == doneReadingTelegram {
-		state = waitingForStartByte
-		return true
+	if fp.state == doneReadingTelegram {
+		fp.state = waitingForStartByte
+		return true, nil
 	}
-	return false
+	return false, nil
 }
 
-func prepareCipherComponents() (iv, cipherText []byte) {
-	iv = append(systemTitle, frameCounter...)
-	cipherText = append(dataPayload, gcmTag...)
-	return
+func (fp *FrameParser) prepareCipherComponents() (iv, cipherText []byte) {
+	frame := fp.frame()
+	return frame.InitialValue(), frame.CipherText()
 }
 
-func openSerialConnection(deviceName string) (reader *bufio.Reader, port *serial.Port) {
-	config := &serial.Config{
-		Name:     deviceName,
-		Baud:     115200,
-		Size:     8,
-		Parity:   serial.ParityNone,
-		StopBits: serial.StopBits(1),
+func (fp *FrameParser) waiting() bool {
+	return fp.state == waitingForStartByte
+}
+
+func (fp *FrameParser) frame() Frame {
+	return Frame{
+		SecurityControl: fp.securityControl,
+		SystemTitle:     append([]byte{}, fp.systemTitle...),
+		FrameCounter:    append([]byte{}, fp.frameCounter...),
+		Payload:         append([]byte{}, fp.dataPayload...),
+		GCMTag:          append([]byte{}, fp.gcmTag...),
 	}
-	port, err := serial.OpenPort(config)
+}
+
+func openSerialConnection(config SerialConfig) (port *serialPort, err error) {
+	// The read timeout wakes up the reader regularly, to notice a port closed during shutdown
+	openedPort, err := serial.OpenPort(config.serialConfig())
 	if err != nil {
-		glog.Fatalln("The serial connection could not be established using the specified device: " + deviceName)
-		glog.Fatalln(err.Error())
-		return nil, nil
+		return nil, fmt.Errorf("%w: %s: %v", ErrDeviceUnavailable, config.Device, err)
 	}
-	return bufio.NewReader(port), port
+	return &serialPort{port: openedPort}, nil
 }
 
-func readTelegram(reader *bufio.Reader) {
-	var ready = false
-	buffer := make([]byte, 4096)
-	for !ready {
-		if length, err := reader.Read(buffer); err == nil && length > 0 {
-			ready = processByteStream(buffer, length)
+// Struct wrapping the serial port, hiding the read timeouts from the TelegramReader
+type serialPort struct {
+	port   *serial.Port
+	closed int32
+}
+
+func (sp *serialPort) Read(p []byte) (n int, err error) {
+	for {
+		n, err = sp.port.Read(p)
+		if atomic.LoadInt32(&sp.closed) != 0 {
+			return 0, ErrClosed
+		}
+		// An elapsed read timeout returns without data, either with or without io.EOF depending on the platform
+		if n > 0 || (err != nil && err != io.EOF) {
+			return n, err
 		}
 	}
 }
 
-func ProcessTelegram(input []byte) (iv, cipherText []byte) {
-	ok := processByteStream(input, len(input))
+func (sp *serialPort) Close() error {
+	atomic.StoreInt32(&sp.closed, 1)
+	return sp.port.Close()
+}
+
+// Struct passing the bytes read from the device on, recording them while a capture is set
+type capturingReader struct {
+	input io.Reader
+	// The running *CaptureWriter, set from other goroutines
+	capture atomic.Value
+}
+
+func (cr *capturingReader) Read(p []byte) (n int, err error) {
+	n, err = cr.input.Read(p)
+	if capture, _ := cr.capture.Load().(*CaptureWriter); capture != nil && n > 0 {
+		if captureErr := capture.WriteChunk(p[:n], time.Now()); captureErr != nil {
+			// Reading goes on without capture, a capture closed by the caller is not an error
+			if captureErr != ErrClosed {
+				glog.Errorf("Capture stopped: %s\n", captureErr)
+			}
+			cr.capture.Store((*CaptureWriter)(nil))
+		}
+	}
+	return n, err
+}
+
+// Splits a single recorded telegram into its tokens
+// Each call uses its own FrameParser, it is therefore safe to use alongside running readers.
+// Parameter:
+// * input: the complete telegram
+// Return:
+// * iv: the initial value (system title + frame counter)
+// * cipherText: the payload with appended gcm tag
+// * err: ErrFraming if the input does not contain a complete telegram
+func ProcessTelegram(input []byte) (iv, cipherText []byte, err error) {
+	parser := NewFrameParser()
+	_, ok, err := parser.processByteStream(input)
+	if err != nil {
+		return nil, nil, err
+	}
 	if !ok {
-		glog.Errorf("Telegram tokenization unable to complete.")
+		return nil, nil, fmt.Errorf("%w: telegram tokenization unable to complete", ErrFraming)
 	}
-	return prepareCipherComponents()
+	iv, cipherText = parser.prepareCipherComponents()
+	return iv, cipherText, nil
 }
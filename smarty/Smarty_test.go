@@ -19,6 +19,8 @@ package smarty_test
 
 import (
     "bytes"
+    "errors"
+    "strings"
     "testing"
 
     "github.com/NEXXTLAB/go-smarty-reader/smarty"
@@ -83,7 +85,10 @@ var gcmTag = originalTelegram[len(originalTelegram)-12:]
 
 // Test if the state machine in Smarty.go splits the byte stream in the required tokens.
 func TestTokenization(t *testing.T) {
-    tokenIV, tokenCipher := smarty.ProcessTelegram(telegram[:])
+    tokenIV, tokenCipher, err := smarty.ProcessTelegram(telegram[:])
+    if err != nil {
+        t.Fatalf("Tokenization failed: %s", err)
+    }
 
     iv := append(systemTitle, frameCounter...)
     cipher := append(payload, gcmTag...)
@@ -100,3 +105,52 @@ func TestTokenization(t *testing.T) {
             ivBool, cipherBool)
     }
 }
+
+// Test if two parsers fed alternately, byte by byte, do not interfere with one another
+// and if a parser keeps the bytes following a complete telegram for the next one.
+func TestIndependentFrameParsers(t *testing.T) {
+    iv := append(append([]byte{}, systemTitle...), frameCounter...)
+    cipher := append(append([]byte{}, payload...), gcmTag...)
+
+    first, second := smarty.NewFrameParser(), smarty.NewFrameParser()
+    for i := range telegram {
+        _, firstReady, _ := first.Process(telegram[i : i+1])
+        _, secondReady, _ := second.Process(telegram[i : i+1])
+        if (firstReady || secondReady) && i != len(telegram)-1 {
+            t.Fatalf("Telegram reported complete at byte %d of %d", i+1, len(telegram))
+        }
+    }
+    for _, parser := range []*smarty.FrameParser{first, second} {
+        tokenIV, tokenCipher := parser.CipherComponents()
+        if !bytes.Equal(tokenIV, iv) || !bytes.Equal(tokenCipher, cipher) {
+            t.Error("Interleaved tokenization failed!")
+        }
+    }
+
+    stream := append(append([]byte{}, telegram[:]...), telegram[:]...)
+    parser := smarty.NewFrameParser()
+    consumed, ready, _ := parser.Process(stream)
+    if !ready || consumed != len(telegram) {
+        t.Fatalf("Expected the first telegram to end after %d bytes, consumed %d", len(telegram), consumed)
+    }
+    if _, ready, _ = parser.Process(stream[consumed:]); !ready {
+        t.Error("Second telegram of the stream was not recognised")
+    }
+}
+
+// Test if a telegram with a broken separator is reported as framing error.
+func TestFramingError(t *testing.T) {
+    // Separator 0x82 following the system title, neither a length prefix nor a length which can hold a frame
+    for _, separator := range []byte{0x00, 0x05, 0x80, 0x83, 0xFF} {
+        broken := append([]byte{}, telegram[:]...)
+        broken[10] = separator
+
+        _, _, err := smarty.ProcessTelegram(broken)
+        if !errors.Is(err, smarty.ErrFraming) || !strings.Contains(err.Error(), "separator") {
+            t.Errorf("Expected ErrFraming for separator 0x%02X, got %v", separator, err)
+        }
+    }
+    if _, _, err := smarty.ProcessTelegram(telegram[:100]); !errors.Is(err, smarty.ErrFraming) {
+        t.Errorf("Expected ErrFraming for a truncated telegram, got %v", err)
+    }
+}
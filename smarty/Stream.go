@@ -0,0 +1,256 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   This file contains the channel and callback based access to the readers. A background goroutine keeps reading
+   the serial port and hands the telegrams over a bounded buffer to the consumers, a slow consumer therefore never
+   blocks the UART unless explicitly requested.
+*/
+
+package smarty
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/golang/glog"
+)
+
+// Struct holding a telegram delivered by Stream and Subscribe
+type Telegram struct {
+	Frame
+	// The decrypted or unencrypted text, nil for telegrams delivered by the CipherForwarder
+	PlainText []byte
+	// The time the telegram has been read
+	ReceivedAt time.Time
+	// The number of telegrams missed since the previous one, according to the frame counter
+	MissedTelegrams uint32
+	// True if the frame counter did not increase (only with ReplayFlag)
+	Replayed bool
+}
+
+// Behaviour once the buffer between the reader and a consumer is full
+type OverflowPolicy int
+
+const (
+	// Discard the oldest buffered telegram in favor of the new one
+	OverflowDropOldest OverflowPolicy = iota
+	// Discard the new telegram
+	OverflowDropNewest
+	// Wait for the consumer, the device is not read in the meantime
+	OverflowBlock
+)
+
+// Struct holding the settings of Stream and Subscribe
+type StreamOptions struct {
+	// Number of telegrams buffered per consumer, at least 1
+	BufferSize int
+	// What to do once the buffer is full
+	Overflow OverflowPolicy
+}
+
+// Returns the default StreamOptions, buffering 16 telegrams (close to 3 minutes) and dropping the oldest on overflow
+func DefaultStreamOptions() StreamOptions {
+	return StreamOptions{
+		BufferSize: 16,
+		Overflow:   OverflowDropOldest,
+	}
+}
+
+// Reads telegrams in a background goroutine until the context ends or the stream fails
+// The channels are closed once reading stopped. Do not call GetTelegram while the stream is running.
+// Parameter:
+// * ctx: cancel this context to stop reading
+// * options: buffer size and overflow policy
+// Return:
+// * telegrams: the decrypted telegrams
+// * errs: ErrFraming, ErrAuthFailed, ErrReplay and ErrOverflow for dropped telegrams, these do not stop the stream,
+//      and finally the error which stopped it (context error, ErrClosed, io.EOF, ...)
+func (od *OnlineDecryptor) Stream(ctx context.Context, options StreamOptions) (<-chan Telegram, <-chan error) {
+	return stream(ctx, od.ReadTelegram, options)
+}
+
+// Reads telegrams in a background goroutine and calls every handler for each of them
+// Each handler runs in its own goroutine with its own buffer, a slow handler does not delay the others.
+// Errors which do not stop the reader are logged.
+// Parameter:
+// * ctx: cancel this context to stop reading, telegrams not yet handed to the handlers are dropped
+// * options: buffer size and overflow policy per handler
+// * handlers: the functions to call for every telegram
+// Return:
+// * err: the error which stopped the reader, once all handlers returned
+func (od *OnlineDecryptor) Subscribe(ctx context.Context, options StreamOptions, handlers ...func(Telegram)) error {
+	return subscribe(ctx, od.ReadTelegram, options, handlers)
+}
+
+// Reads telegrams in a background goroutine until the context ends or the stream fails
+// See OnlineDecryptor.Stream, the telegrams are delivered without plain text.
+func (cf *CipherForwarder) Stream(ctx context.Context, options StreamOptions) (<-chan Telegram, <-chan error) {
+	return stream(ctx, cf.nextTelegram, options)
+}
+
+// Reads telegrams in a background goroutine and calls every handler for each of them
+// See OnlineDecryptor.Subscribe, the telegrams are delivered without plain text.
+func (cf *CipherForwarder) Subscribe(ctx context.Context, options StreamOptions, handlers ...func(Telegram)) error {
+	return subscribe(ctx, cf.nextTelegram, options, handlers)
+}
+
+func (cf *CipherForwarder) nextTelegram(ctx context.Context) (Telegram, error) {
+	frame, err := cf.deviceInfo.reader.ReadTelegramContext(ctx)
+	if err != nil {
+		return Telegram{}, err
+	}
+	return Telegram{Frame: frame, ReceivedAt: time.Now()}, nil
+}
+
+type telegramSource func(ctx context.Context) (Telegram, error)
+
+// Number of errors buffered by Stream, further errors are discarded until the consumer catches up
+const streamErrorBufferSize = 16
+
+// Errors concerning a single telegram, the reader continues with the next one
+func isTelegramError(err error) bool {
+	return errors.Is(err, ErrFraming) || errors.Is(err, ErrAuthFailed) || errors.Is(err, ErrReplay)
+}
+
+func stream(ctx context.Context, next telegramSource, options StreamOptions) (<-chan Telegram, <-chan error) {
+	if options.BufferSize < 1 {
+		options.BufferSize = 1
+	}
+	telegrams := make(chan Telegram, options.BufferSize)
+	// One slot stays reserved for the final error, so it never blocks
+	errs := make(chan error, streamErrorBufferSize+1)
+	reportError := func(err error) {
+		if len(errs) < cap(errs)-1 {
+			errs <- err
+		}
+	}
+
+	go func() {
+		defer close(errs)
+		defer close(telegrams)
+		for {
+			telegram, err := next(ctx)
+			if err != nil {
+				if isTelegramError(err) && ctx.Err() == nil {
+					reportError(err)
+					continue
+				}
+				errs <- err
+				return
+			}
+			// A telegram waiting for the consumer during shutdown is not an overflow
+			if !deliver(ctx, telegrams, telegram, options.Overflow) && ctx.Err() == nil {
+				reportError(fmt.Errorf("%w: dropped telegram with frame counter %X",
+					ErrOverflow, telegram.FrameCounter))
+			}
+		}
+	}()
+	return telegrams, errs
+}
+
+// Hands the telegram to the consumer according to the overflow policy
+// Returns false if a telegram had to be dropped, or the context ended while waiting for the consumer.
+func deliver(ctx context.Context, telegrams chan Telegram, telegram Telegram, policy OverflowPolicy) bool {
+	switch policy {
+	case OverflowBlock:
+		select {
+		case telegrams <- telegram:
+			return true
+		case <-ctx.Done():
+			return false
+		}
+	case OverflowDropNewest:
+		select {
+		case telegrams <- telegram:
+			return true
+		default:
+			return false
+		}
+	default:
+		dropped := false
+		for {
+			select {
+			case telegrams <- telegram:
+				return !dropped
+			default:
+				// Make room by removing the oldest telegram, unless the consumer was faster
+				select {
+				case <-telegrams:
+					dropped = true
+				default:
+				}
+			}
+		}
+	}
+}
+
+func subscribe(ctx context.Context, next telegramSource, options StreamOptions, handlers []func(Telegram)) error {
+	if options.BufferSize < 1 {
+		options.BufferSize = 1
+	}
+	var wg sync.WaitGroup
+	queues := make([]chan Telegram, len(handlers))
+	for i, handler := range handlers {
+		queues[i] = make(chan Telegram, options.BufferSize)
+		wg.Add(1)
+		go func(queue <-chan Telegram, handler func(Telegram)) {
+			defer wg.Done()
+			for telegram := range queue {
+				handler(telegram)
+			}
+		}(queues[i], handler)
+	}
+
+	telegrams, errs := stream(ctx, next, options)
+	var err error
+	for telegrams != nil || errs != nil {
+		select {
+		case telegram, ok := <-telegrams:
+			if !ok {
+				telegrams = nil
+				continue
+			}
+			for i, queue := range queues {
+				// Cancelling the context also stops waiting for a blocked handler (OverflowBlock)
+				if !deliver(ctx, queue, telegram, options.Overflow) && ctx.Err() == nil {
+					glog.Warningf("Handler %d too slow, dropped telegram with frame counter %X\n",
+						i, telegram.FrameCounter)
+				}
+			}
+		case streamErr, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			if isTelegramError(streamErr) || errors.Is(streamErr, ErrOverflow) {
+				glog.Warningln(streamErr.Error())
+			} else {
+				err = streamErr
+			}
+		}
+	}
+
+	for _, queue := range queues {
+		close(queue)
+	}
+	wg.Wait()
+	return err
+}
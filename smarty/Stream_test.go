@@ -0,0 +1,187 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package smarty_test
+
+import (
+    "bytes"
+    "context"
+    "errors"
+    "io"
+    "sync"
+    "sync/atomic"
+    "testing"
+    "time"
+
+    "github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+// Three valid telegrams with a modified one in between
+func streamInput() io.Reader {
+    modified := append([]byte{}, telegram[:]...)
+    modified[len(modified)-1] ^= 0x01
+
+    var input bytes.Buffer
+    input.Write(telegram[:])
+    input.Write(modified)
+    input.Write(telegram[:])
+    input.Write(telegram[:])
+    return &input
+}
+
+// Test if the stream delivers every valid telegram, reports the modified one and ends with the stream error.
+func TestStream(t *testing.T) {
+    smartyObj, err := smarty.NewOnlineDecryptorFromReader(streamInput(), key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    options := smarty.DefaultStreamOptions()
+    options.Overflow = smarty.OverflowBlock
+    telegrams, errs := smartyObj.Stream(context.Background(), options)
+
+    received := 0
+    for telegram := range telegrams {
+        if !bytes.HasPrefix(telegram.PlainText, []byte("/Lux5")) {
+            t.Errorf("Unexpected plain text: \n%s\n", telegram.PlainText)
+        }
+        received++
+    }
+    if received != 3 {
+        t.Errorf("Expected 3 telegrams, received %d", received)
+    }
+
+    var streamErrs []error
+    for err := range errs {
+        streamErrs = append(streamErrs, err)
+    }
+    if len(streamErrs) != 2 || !errors.Is(streamErrs[0], smarty.ErrAuthFailed) || streamErrs[1] != io.EOF {
+        t.Errorf("Expected ErrAuthFailed followed by io.EOF, got %v", streamErrs)
+    }
+}
+
+// Test if cancelling a stream blocked by its consumer does not report the waiting telegram as overflow.
+func TestStreamCancelBlocked(t *testing.T) {
+    smartyObj, err := smarty.NewOnlineDecryptorFromReader(streamInput(), key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+    _, errs := smartyObj.Stream(ctx, smarty.StreamOptions{BufferSize: 1, Overflow: smarty.OverflowBlock})
+
+    // The modified telegram follows the buffered one, the next valid telegram waits for the consumer
+    if err = <-errs; !errors.Is(err, smarty.ErrAuthFailed) {
+        t.Fatalf("Expected ErrAuthFailed, got %v", err)
+    }
+    time.Sleep(50 * time.Millisecond)
+    cancel()
+
+    var streamErrs []error
+    for err := range errs {
+        streamErrs = append(streamErrs, err)
+    }
+    if len(streamErrs) != 1 || streamErrs[0] != context.Canceled {
+        t.Errorf("Expected context.Canceled only, got %v", streamErrs)
+    }
+}
+
+// Test if a consumer not keeping up only receives the newest telegram with OverflowDropOldest.
+func TestStreamDropOldest(t *testing.T) {
+    smartyObj, err := smarty.NewOnlineDecryptorFromReader(streamInput(), key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    telegrams, errs := smartyObj.Stream(context.Background(),
+        smarty.StreamOptions{BufferSize: 1, Overflow: smarty.OverflowDropOldest})
+
+    overflows := 0
+    for err := range errs {
+        if errors.Is(err, smarty.ErrOverflow) {
+            overflows++
+        }
+    }
+    if overflows != 2 {
+        t.Errorf("Expected 2 dropped telegrams, got %d", overflows)
+    }
+    received := 0
+    for range telegrams {
+        received++
+    }
+    if received != 1 {
+        t.Errorf("Expected 1 buffered telegram, received %d", received)
+    }
+}
+
+// Test if every subscribed handler receives every telegram.
+func TestSubscribe(t *testing.T) {
+    smartyObj := smarty.NewCipherForwarderFromReader(streamInput())
+
+    var mutex sync.Mutex
+    counts := make([]int, 2)
+    handler := func(i int) func(smarty.Telegram) {
+        return func(telegram smarty.Telegram) {
+            mutex.Lock()
+            defer mutex.Unlock()
+            counts[i]++
+        }
+    }
+    options := smarty.StreamOptions{BufferSize: 4, Overflow: smarty.OverflowBlock}
+    err := smartyObj.Subscribe(context.Background(), options, handler(0), handler(1))
+    if err != io.EOF {
+        t.Errorf("Expected io.EOF, got %v", err)
+    }
+    for i, count := range counts {
+        if count != 4 {
+            t.Errorf("Handler %d received %d telegrams instead of 4", i, count)
+        }
+    }
+}
+
+// Test if cancelling the context stops waiting for a blocked handler, the remaining telegrams are dropped.
+func TestSubscribeCancelBlocked(t *testing.T) {
+    smartyObj := smarty.NewCipherForwarderFromReader(streamInput())
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    called := make(chan struct{}, 4)
+    release := make(chan struct{})
+    var handled int32
+    handler := func(telegram smarty.Telegram) {
+        called <- struct{}{}
+        <-release
+        atomic.AddInt32(&handled, 1)
+    }
+    done := make(chan error, 1)
+    go func() {
+        done <- smartyObj.Subscribe(ctx, smarty.StreamOptions{BufferSize: 1, Overflow: smarty.OverflowBlock}, handler)
+    }()
+
+    // The first telegram blocks the handler, the second one fills its buffer and the third one waits
+    <-called
+    time.Sleep(50 * time.Millisecond)
+    cancel()
+    time.Sleep(50 * time.Millisecond)
+    close(release)
+
+    // The reader may have reached the end of the input before the cancellation
+    if err := <-done; err != context.Canceled && err != io.EOF {
+        t.Errorf("Expected context.Canceled or io.EOF, got %v", err)
+    }
+    if handled != 2 {
+        t.Errorf("Expected 2 handled telegrams, got %d", handled)
+    }
+}
@@ -0,0 +1,119 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   The system title identifies the meter which sent a telegram, without decrypting it. Its 8 bytes follow the
+   IDIS structure:
+       bytes 0-2: manufacturer code (FLAG ID), eg. "SAG" for Sagemcom
+       byte  3:   device type
+       bytes 4-7: function type (upper 4 bits) and serial number (lower 28 bits)
+   On the smarty the serial number matches the last digits of the equipment identifier (OBIS 0-0:42.0.0).
+*/
+
+package smarty
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+)
+
+// The system title of a meter, as sent in every telegram
+type SystemTitle []byte
+
+// Names of common meter manufacturers by FLAG ID
+var manufacturerNames = map[string]string{
+	"SAG": "Sagemcom",
+	"KFM": "Kaifa",
+	"ISK": "Iskraemeco",
+	"LGZ": "Landis+Gyr",
+	"ELS": "Elster",
+	"KAM": "Kamstrup",
+	"EMH": "EMH metering",
+	"ZPA": "ZPA Smart Energy",
+}
+
+// Returns the 3 letter manufacturer code, eg. "SAG"
+func (st SystemTitle) Manufacturer() string {
+	if !st.valid() {
+		return ""
+	}
+	return string(st[:3])
+}
+
+// Returns the name of the manufacturer, or the manufacturer code if the manufacturer is unknown
+func (st SystemTitle) ManufacturerName() string {
+	return ManufacturerName(st.Manufacturer())
+}
+
+// Returns the name of a manufacturer, eg. "Sagemcom" for "SAG", or the code itself if the manufacturer is unknown
+// The equipment identifier (OBIS 0-0:42.0.0) starts with the same manufacturer code.
+func ManufacturerName(code string) string {
+	if name, known := manufacturerNames[code]; known {
+		return name
+	}
+	return code
+}
+
+// Returns the device type
+func (st SystemTitle) DeviceType() byte {
+	if !st.valid() {
+		return 0
+	}
+	return st[3]
+}
+
+// Returns the function type
+func (st SystemTitle) FunctionType() byte {
+	if !st.valid() {
+		return 0
+	}
+	return st[4] >> 4
+}
+
+// Returns the serial number of the device
+func (st SystemTitle) SerialNumber() uint32 {
+	if !st.valid() {
+		return 0
+	}
+	return binary.BigEndian.Uint32(st[4:]) & 0x0FFFFFFF
+}
+
+// Returns the system title as hex string, eg. "534147677001bd54"
+func (st SystemTitle) Hex() string {
+	return hex.EncodeToString(st)
+}
+
+// Returns the manufacturer code followed by the serial number, eg. "SAG-114004"
+func (st SystemTitle) String() string {
+	if !st.valid() {
+		return st.Hex()
+	}
+	return fmt.Sprintf("%s-%d", st.Manufacturer(), st.SerialNumber())
+}
+
+func (st SystemTitle) valid() bool {
+	if len(st) != systemTitleLength {
+		return false
+	}
+	for _, character := range st[:3] {
+		if character < 'A' || character > 'Z' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,51 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package smarty_test
+
+import (
+    "bytes"
+    "testing"
+
+    "github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+// Test if the system title of the pre-recorded telegram is decoded
+// The serial number matches the equipment identifier SAG1030700114004 of the decrypted telegram.
+func TestSystemTitle(t *testing.T) {
+    frame, err := smarty.NewTelegramReader(bytes.NewReader(telegram[:])).ReadTelegram()
+    if err != nil {
+        t.Fatal(err)
+    }
+    title := frame.SystemTitle
+
+    if title.Manufacturer() != "SAG" || title.ManufacturerName() != "Sagemcom" {
+        t.Errorf("Unexpected manufacturer %q (%q)", title.Manufacturer(), title.ManufacturerName())
+    }
+    if title.SerialNumber() != 114004 || title.DeviceType() != 'g' || title.FunctionType() != 7 {
+        t.Errorf("Unexpected serial number %d, device type %X or function type %X",
+            title.SerialNumber(), title.DeviceType(), title.FunctionType())
+    }
+    if title.String() != "SAG-114004" || title.Hex() != "534147677001bd54" {
+        t.Errorf("Unexpected string representation %q / %q", title.String(), title.Hex())
+    }
+
+    invalid := smarty.SystemTitle{0x00, 0x01}
+    if invalid.Manufacturer() != "" || invalid.SerialNumber() != 0 || invalid.String() != "0001" {
+        t.Errorf("Invalid system title decoded as %q", invalid.String())
+    }
+}
@@ -0,0 +1,221 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   The TelegramReader splits any byte stream (serial port, TCP socket, pipe, recorded file) into smarty telegrams.
+   It is the common base of the OnlineDecryptor and the CipherForwarder, which simply plug a serial port into it.
+   Depending on its ReaderMode it reads encrypted smarty telegrams, plain text DSMR telegrams or detects the format.
+*/
+
+package smarty
+
+import (
+	"bufio"
+	"context"
+	"io"
+
+	"github.com/golang/glog"
+)
+
+// Struct holding the tokens of a single smarty telegram
+type Frame struct {
+	// Selects authentication (0x10), encryption (0x20) or both (0x30, smarty), see Security.go
+	SecurityControl byte
+	SystemTitle     SystemTitle
+	FrameCounter    []byte
+	Payload         []byte
+	GCMTag          []byte
+	// The complete telegram of an unencrypted DSMR meter, the other tokens are empty in this case
+	Unencrypted []byte
+}
+
+// Returns false for telegrams of unencrypted DSMR meters
+func (f Frame) Encrypted() bool {
+	return f.Unencrypted == nil
+}
+
+// Returns the initial value as specified in the smarty documentation (system title + frame counter)
+func (f Frame) InitialValue() []byte {
+	return append(append([]byte{}, f.SystemTitle...), f.FrameCounter...)
+}
+
+// Returns the payload with appended gcm tag, as expected by Decryptor.Decrypt
+func (f Frame) CipherText() []byte {
+	return append(append([]byte{}, f.Payload...), f.GCMTag...)
+}
+
+// Struct reading smarty telegrams from an arbitrary byte stream
+// A TelegramReader is not safe for concurrent reads, closing the underlying stream from another goroutine is.
+type TelegramReader struct {
+	reader *bufio.Reader
+	mode   ReaderMode
+	// The parser of the telegram format in use, switched by ModeAuto
+	parser    byteStreamParser
+	encrypted *FrameParser
+	plainText *PlainTextParser
+	// Result of a read which outlived its context, handed to the next caller
+	pending chan readResult
+}
+
+// Common methods of the FrameParser and the PlainTextParser
+type byteStreamParser interface {
+	processByteStream(input []byte) (consumed int, ready bool, err error)
+	waiting() bool
+	frame() Frame
+}
+
+type readResult struct {
+	frame Frame
+	err   error
+}
+
+// Creation of a new TelegramReader
+// Parameter:
+// * input: the byte stream to read the telegrams from
+// Return:
+// * TelegramReader: a new object to execute methods on
+func NewTelegramReader(input io.Reader) *TelegramReader {
+	return NewTelegramReaderWithMode(input, ModeEncrypted)
+}
+
+// Creation of a new TelegramReader for plain text or mixed streams
+// Parameter:
+// * input: the byte stream to read the telegrams from
+// * mode: the telegram format, see ReaderMode
+// Return:
+// * TelegramReader: a new object to execute methods on
+func NewTelegramReaderWithMode(input io.Reader, mode ReaderMode) *TelegramReader {
+	tr := &TelegramReader{
+		reader:    bufio.NewReader(input),
+		encrypted: NewFrameParser(),
+		plainText: NewPlainTextParser(),
+	}
+	tr.SetMode(mode)
+	return tr
+}
+
+// Sets the length of the authentication tag of encrypted telegrams, do not call while a read is running
+// Parameter:
+// * tagLength: 12 (smarty, default) or 16 bytes
+func (tr *TelegramReader) SetTagLength(tagLength int) {
+	tr.encrypted.SetTagLength(tagLength)
+}
+
+// Sets the format of the telegrams, do not call while a read is running
+// Parameter:
+// * mode: ModeEncrypted (default), ModePlainText or ModeAuto
+func (tr *TelegramReader) SetMode(mode ReaderMode) {
+	tr.mode = mode
+	tr.parser = tr.encrypted
+	if mode == ModePlainText {
+		tr.parser = tr.plainText
+	}
+}
+
+// Waits for the next complete telegram in the stream
+// Bytes preceding the start byte are skipped. Telegrams of unencrypted meters are returned in Frame.Unencrypted.
+// Return:
+// * frame: the tokens of the telegram
+// * err: ErrFraming if a telegram with invalid separators was dropped, the next call continues after it,
+//      io.EOF if the stream ended between two telegrams, io.ErrUnexpectedEOF if it ended within a telegram,
+//      or the error returned by the underlying stream
+func (tr *TelegramReader) ReadTelegram() (frame Frame, err error) {
+	return tr.ReadTelegramContext(context.Background())
+}
+
+// Waits for the next complete telegram in the stream, until the context is cancelled or its deadline expires
+// A read interrupted by the context keeps running in the background, its telegram is returned by the next call.
+// Parameter:
+// * ctx: the context limiting the wait
+// Return:
+// * frame: the tokens of the telegram
+// * err: the context error if the context ended first, otherwise the same errors as ReadTelegram
+func (tr *TelegramReader) ReadTelegramContext(ctx context.Context) (frame Frame, err error) {
+	if err = ctx.Err(); err != nil {
+		return Frame{}, err
+	}
+	if tr.pending == nil {
+		if ctx.Done() == nil {
+			// The context can never be cancelled, no need for a background read
+			return tr.readTelegram()
+		}
+		tr.pending = make(chan readResult, 1)
+		go func(result chan<- readResult) {
+			frame, err := tr.readTelegram()
+			result <- readResult{frame: frame, err: err}
+		}(tr.pending)
+	}
+	select {
+	case result := <-tr.pending:
+		tr.pending = nil
+		return result.frame, result.err
+	case <-ctx.Done():
+		return Frame{}, ctx.Err()
+	}
+}
+
+func (tr *TelegramReader) readTelegram() (frame Frame, err error) {
+	for {
+		// Wait until data is available, then hand everything buffered to the parser.
+		// Bytes following a complete telegram stay in the reader for the next call.
+		if _, err = tr.reader.Peek(1); err != nil {
+			if err == io.EOF && !tr.parser.waiting() {
+				err = io.ErrUnexpectedEOF
+			}
+			return Frame{}, err
+		}
+		buffer, _ := tr.reader.Peek(tr.reader.Buffered())
+		consumed, ready, err := tr.process(buffer)
+		tr.reader.Discard(consumed)
+		if err != nil {
+			return Frame{}, err
+		}
+		if ready {
+			if tr.mode == ModeAuto {
+				// Stick to the detected format, a '/' within the cipher text can not be taken for a telegram
+				glog.Infof("Detected %s telegrams\n", tr.modeOf(tr.parser))
+				tr.SetMode(tr.modeOf(tr.parser))
+			}
+			return tr.parser.frame(), nil
+		}
+	}
+}
+
+func (tr *TelegramReader) process(input []byte) (consumed int, ready bool, err error) {
+	if tr.mode == ModeAuto && tr.parser.waiting() {
+		// Skip to the start byte of either format and select the matching parser
+		for consumed < len(input) && input[consumed] != 0xDB && input[consumed] != '/' {
+			consumed++
+		}
+		if consumed == len(input) {
+			return consumed, false, nil
+		}
+		tr.parser = tr.encrypted
+		if input[consumed] == '/' {
+			tr.parser = tr.plainText
+		}
+	}
+	processed, ready, err := tr.parser.processByteStream(input[consumed:])
+	return consumed + processed, ready, err
+}
+
+func (tr *TelegramReader) modeOf(parser byteStreamParser) ReaderMode {
+	if parser == byteStreamParser(tr.plainText) {
+		return ModePlainText
+	}
+	return ModeEncrypted
+}
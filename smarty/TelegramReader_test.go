@@ -0,0 +1,101 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package smarty_test
+
+import (
+    "bytes"
+    "context"
+    "io"
+    "testing"
+    "time"
+
+    "github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+// Test if the TelegramReader finds both telegrams of a stream, skipping the noise in front of them
+// and reporting the truncated telegram at the end.
+func TestTelegramReader(t *testing.T) {
+    var stream bytes.Buffer
+    stream.Write([]byte{0x00, 0x42, 0xFF})
+    stream.Write(telegram[:])
+    stream.Write(telegram[:])
+    stream.Write(telegram[:100])
+
+    reader := smarty.NewTelegramReader(&stream)
+    for i := 0; i < 2; i++ {
+        frame, err := reader.ReadTelegram()
+        if err != nil {
+            t.Fatalf("Telegram %d could not be read: %s", i+1, err)
+        }
+        if !bytes.Equal(frame.SystemTitle, systemTitle) || !bytes.Equal(frame.FrameCounter, frameCounter) ||
+            !bytes.Equal(frame.Payload, payload) || !bytes.Equal(frame.GCMTag, gcmTag) {
+            t.Errorf("Telegram %d was not split into the expected tokens", i+1)
+        }
+    }
+    if _, err := reader.ReadTelegram(); err != io.ErrUnexpectedEOF {
+        t.Errorf("Expected io.ErrUnexpectedEOF for the truncated telegram, got %v", err)
+    }
+}
+
+// Test the whole OnlineDecryptor pipeline without a serial device.
+func TestOnlineDecryptorFromReader(t *testing.T) {
+    smartyObj, err := smarty.NewOnlineDecryptorFromReader(bytes.NewReader(telegram[:]), key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer smartyObj.Disconnect()
+
+    plainText, err := smartyObj.GetTelegram()
+    if err != nil {
+        t.Fatalf("Decryption failed! %s", err)
+    }
+    if !bytes.HasPrefix(plainText, []byte("/Lux5")) {
+        t.Errorf("Unexpected plain text: \n%s\n", plainText)
+    }
+}
+
+// Test if a read honors the context deadline, keeps the interrupted read for the next call
+// and if closing the stream from another goroutine ends a waiting read.
+func TestGetTelegramContext(t *testing.T) {
+    pipeReader, pipeWriter := io.Pipe()
+    smartyObj, err := smarty.NewOnlineDecryptorFromReader(pipeReader, key)
+    if err != nil {
+        t.Fatal(err)
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+    defer cancel()
+    if _, err := smartyObj.GetTelegramContext(ctx); err != context.DeadlineExceeded {
+        t.Fatalf("Expected context.DeadlineExceeded, got %v", err)
+    }
+
+    go pipeWriter.Write(telegram[:])
+    ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+    defer cancel()
+    if _, err := smartyObj.GetTelegramContext(ctx); err != nil {
+        t.Fatalf("Decryption failed! %s", err)
+    }
+
+    go func() {
+        time.Sleep(50 * time.Millisecond)
+        smartyObj.Disconnect()
+    }()
+    if _, err := smartyObj.GetTelegramContext(ctx); err != io.ErrClosedPipe {
+        t.Errorf("Expected io.ErrClosedPipe after Disconnect, got %v", err)
+    }
+}
@@ -0,0 +1,77 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   The text representation of the cipher components, as written by the CipherForwarding example to be decrypted
+   at a later date: one telegram per line, holding the initial value, the cipher text and the gcm tag as hex strings
+   separated by spaces.
+
+       534147677001BD540005A8E3 806EE6E6...F2850F17 ...
+
+   Empty lines and lines starting with '#' are ignored by the readers of the format.
+*/
+package smarty
+
+import (
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// Returns the frame as text line: initial value, cipher text and gcm tag in hex, separated by spaces
+func (f Frame) Tuple() string {
+	fields := []string{hex.EncodeToString(f.InitialValue()), hex.EncodeToString(f.Payload)}
+	if len(f.GCMTag) > 0 {
+		fields = append(fields, hex.EncodeToString(f.GCMTag))
+	}
+	return strings.ToUpper(strings.Join(fields, " "))
+}
+
+// Parses a text line written by Frame.Tuple
+// Parameter:
+// * line: initial value, cipher text and gcm tag in hex, separated by spaces or tabs
+// Return:
+// * frame: the tokens of the telegram, without security control byte
+// * err: ErrFraming if the line does not hold a valid tuple
+func ParseTuple(line string) (frame Frame, err error) {
+	// Telegrams without authentication (0x20) have no tag
+	fields := strings.Fields(line)
+	if len(fields) == 2 {
+		fields = append(fields, "")
+	}
+	if len(fields) != 3 {
+		return Frame{}, fmt.Errorf("%w: tuple of %d fields, expected initial value, cipher text and gcm tag",
+			ErrFraming, len(fields))
+	}
+	tokens := make([][]byte, len(fields))
+	for i, field := range fields {
+		if tokens[i], err = hex.DecodeString(field); err != nil {
+			return Frame{}, fmt.Errorf("%w: tuple field %d: %v", ErrFraming, i+1, err)
+		}
+	}
+	initialValue := tokens[0]
+	if len(initialValue) != systemTitleLength+4 {
+		return Frame{}, fmt.Errorf("%w: initial value of %v bytes, expected %v bytes",
+			ErrFraming, len(initialValue), systemTitleLength+4)
+	}
+	return Frame{
+		SystemTitle:  initialValue[:systemTitleLength],
+		FrameCounter: initialValue[systemTitleLength:],
+		Payload:      tokens[1],
+		GCMTag:       tokens[2],
+	}, nil
+}
@@ -0,0 +1,56 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package smarty_test
+
+import (
+    "bytes"
+    "errors"
+    "testing"
+
+    "github.com/NEXXTLAB/go-smarty-reader/smarty"
+)
+
+// Test if the pre-recorded telegram survives the conversion to a tuple line and back
+func TestTuple(t *testing.T) {
+    frame, err := smarty.NewTelegramReader(bytes.NewReader(telegram[:])).ReadTelegram()
+    if err != nil {
+        t.Fatal(err)
+    }
+    parsed, err := smarty.ParseTuple(frame.Tuple())
+    if err != nil {
+        t.Fatal(err)
+    }
+    if !bytes.Equal(parsed.InitialValue(), frame.InitialValue()) || !bytes.Equal(parsed.CipherText(),
+        frame.CipherText()) {
+        t.Error("Parsed tuple differs from the original telegram")
+    }
+
+    decryptor, err := smarty.NewDecryptor(key)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if _, err = decryptor.Decrypt(parsed.InitialValue(), parsed.CipherText()); err != nil {
+        t.Errorf("Parsed tuple could not be decrypted: %s", err)
+    }
+
+    for _, invalid := range []string{"", "534147677001BD540005A8E3", "534147677001BD54 00 00", "XY 00 00"} {
+        if _, err := smarty.ParseTuple(invalid); !errors.Is(err, smarty.ErrFraming) {
+            t.Errorf("Expected ErrFraming for %q, got %v", invalid, err)
+        }
+    }
+}
@@ -0,0 +1,111 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   The telegram ends with '!' followed by the CRC16 checksum (CRC-16/ARC, polynomial 0xA001 reflected, initial
+   value 0) over all characters from the leading '/' up to and including the '!', as 4 hexadecimal characters.
+*/
+
+package obis
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strconv"
+)
+
+// Behaviour of the parser if the checksum does not match
+type ChecksumMode int
+
+const (
+	// Reject the telegram with a ChecksumError
+	ChecksumStrict ChecksumMode = iota
+	// Keep the telegram, Reading.ChecksumValid is false
+	ChecksumLenient
+)
+
+// Returned by errors.Is for every ChecksumError
+var ErrChecksum = errors.New("obis: checksum mismatch")
+
+// Error holding the details of a failed checksum verification
+type ChecksumError struct {
+	// The checksum sent with the telegram
+	Expected uint16
+	// The checksum computed over the telegram
+	Computed uint16
+	// True if the telegram does not carry a checksum
+	Missing bool
+}
+
+func (e *ChecksumError) Error() string {
+	if e.Missing {
+		return "obis: checksum missing"
+	}
+	return fmt.Sprintf("obis: checksum mismatch, expected %04X, computed %04X", e.Expected, e.Computed)
+}
+
+func (e *ChecksumError) Is(target error) bool {
+	return target == ErrChecksum
+}
+
+// Computes the CRC-16/ARC checksum
+// Parameter:
+// * data: the telegram from the leading '/' up to and including the '!'
+// Return:
+// * crc: the checksum
+func Checksum(data []byte) (crc uint16) {
+	for _, character := range data {
+		crc ^= uint16(character)
+		for bit := 0; bit < 8; bit++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0xA001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Verifies the checksum at the end of a telegram
+// Parameter:
+// * plainText: the complete telegram, starting with '/'
+// Return:
+// * err: a ChecksumError if the checksum is missing or does not match, ErrFormat if the telegram has no end
+func VerifyChecksum(plainText []byte) error {
+	start := bytes.IndexByte(plainText, '/')
+	end := bytes.LastIndexByte(plainText, '!')
+	if start < 0 || end < start {
+		return fmt.Errorf("%w: telegram start ('/') or end ('!') missing", ErrFormat)
+	}
+
+	sent := bytes.TrimRight(plainText[end+1:], "\r\n")
+	if len(sent) == 0 {
+		return &ChecksumError{Missing: true}
+	}
+	expected, err := strconv.ParseUint(string(sent), 16, 16)
+	if err != nil || len(sent) != 4 {
+		return fmt.Errorf("%w: invalid checksum %q", ErrFormat, sent)
+	}
+
+	computed := Checksum(plainText[start : end+1])
+	if computed != uint16(expected) {
+		return &ChecksumError{Expected: uint16(expected), Computed: computed}
+	}
+	return nil
+}
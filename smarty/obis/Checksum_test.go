@@ -0,0 +1,98 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package obis_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/NEXXTLAB/go-smarty-reader/smarty/obis"
+)
+
+// The unmodified decrypted telegram of the testing device at NEXXTLAB, including the checksum sent by the meter
+var recordedTelegram = strings.Join([]string{
+	"/Lux5\\253663629_D",
+	"",
+	"1-3:0.2.8(42)",
+	"0-0:1.0.0(180130102122W)",
+	"0-0:42.0.0(53414731303330373030313134303034)",
+	"1-0:1.8.0(000006.695*kWh)",
+	"1-0:2.8.0(000000.025*kWh)",
+	"1-0:3.8.0(000000.818*kvarh)",
+	"1-0:4.8.0(000002.745*kvarh)",
+	"1-0:1.7.0(00.000*kW)",
+	"1-0:2.7.0(00.000*kW)",
+	"1-0:3.7.0(00.000)",
+	"1-0:4.7.0(00.007)",
+	"0-0:17.0.0(77.376)",
+	"0-0:96.3.10(1)",
+	"0-0:96.7.21(00069)",
+	"1-0:32.32.0(00044)",
+	"1-0:52.32.0(00003)",
+	"1-0:72.32.0(00002)",
+	"1-0:32.36.0(00000)",
+	"1-0:52.36.0(00000)",
+	"1-0:72.36.0(00000)",
+	"0-0:96.13.0()",
+	"0-0:96.13.2()",
+	"0-0:96.13.3()",
+	"0-0:96.13.4()",
+	"0-0:96.13.5()",
+	"1-0:31.7.0(000*A)",
+	"1-0:51.7.0(000*A)",
+	"1-0:71.7.0(000*A)",
+	"!CFDE",
+	"",
+}, "\r\n")
+
+// Test if the checksum sent by the meter is verified
+func TestVerifyChecksum(t *testing.T) {
+	if err := obis.VerifyChecksum([]byte(recordedTelegram)); err != nil {
+		t.Errorf("Checksum of the recorded telegram rejected: %s", err)
+	}
+
+	modified := strings.Replace(recordedTelegram, "000006.695", "000009.695", 1)
+	var checksumErr *obis.ChecksumError
+	err := obis.VerifyChecksum([]byte(modified))
+	if !errors.As(err, &checksumErr) || checksumErr.Expected != 0xCFDE || !errors.Is(err, obis.ErrChecksum) {
+		t.Errorf("Expected a checksum mismatch, got %v", err)
+	}
+
+	missing := strings.Replace(recordedTelegram, "!CFDE", "!", 1)
+	if err = obis.VerifyChecksum([]byte(missing)); !errors.As(err, &checksumErr) || !checksumErr.Missing {
+		t.Errorf("Expected a missing checksum, got %v", err)
+	}
+}
+
+// Test if strict mode drops and lenient mode flags a telegram with invalid checksum
+func TestChecksumModes(t *testing.T) {
+	reading, err := obis.Parse([]byte(recordedTelegram))
+	if err != nil || !reading.ChecksumValid {
+		t.Errorf("Recorded telegram rejected: %v", err)
+	}
+
+	modified := []byte(strings.Replace(recordedTelegram, "000006.695", "000009.695", 1))
+	if _, err = obis.ParseWithMode(modified, obis.ChecksumStrict); !errors.Is(err, obis.ErrChecksum) {
+		t.Errorf("Expected ErrChecksum in strict mode, got %v", err)
+	}
+	reading, err = obis.ParseWithMode(modified, obis.ChecksumLenient)
+	if err != nil || reading.ChecksumValid || reading.EnergyImport != 9.695 {
+		t.Errorf("Expected a flagged reading in lenient mode, got %+v, %v", reading, err)
+	}
+}
@@ -0,0 +1,314 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+/*
+   The obis package parses the decrypted smarty telegram (DSMR P1 format) into a typed Reading.
+   Every line of the telegram holds one OBIS code followed by one or more values in brackets, eg.
+       1-0:1.8.0(000006.695*kWh)
+   Please find the meaning of the OBIS codes in the smarty specification.
+*/
+
+package obis
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// OBIS codes of the Luxembourgian smarty
+const (
+	Version              = "1-3:0.2.8"
+	Timestamp            = "0-0:1.0.0"
+	EquipmentID          = "0-0:42.0.0"
+	EnergyImport         = "1-0:1.8.0"
+	EnergyExport         = "1-0:2.8.0"
+	ReactiveEnergyImport = "1-0:3.8.0"
+	ReactiveEnergyExport = "1-0:4.8.0"
+	PowerImport          = "1-0:1.7.0"
+	PowerExport          = "1-0:2.7.0"
+	ReactivePowerImport  = "1-0:3.7.0"
+	ReactivePowerExport  = "1-0:4.7.0"
+	PowerImportL1        = "1-0:21.7.0"
+	PowerImportL2        = "1-0:41.7.0"
+	PowerImportL3        = "1-0:61.7.0"
+	PowerExportL1        = "1-0:22.7.0"
+	PowerExportL2        = "1-0:42.7.0"
+	PowerExportL3        = "1-0:62.7.0"
+	VoltageL1            = "1-0:32.7.0"
+	VoltageL2            = "1-0:52.7.0"
+	VoltageL3            = "1-0:72.7.0"
+	CurrentL1            = "1-0:31.7.0"
+	CurrentL2            = "1-0:51.7.0"
+	CurrentL3            = "1-0:71.7.0"
+	ActiveThreshold      = "0-0:17.0.0"
+	BreakerState         = "0-0:96.3.10"
+	PowerFailures        = "0-0:96.7.21"
+)
+
+// The telegram does not follow the P1 format
+var ErrFormat = errors.New("obis: malformed telegram")
+
+// Struct holding a single line of the telegram
+type Object struct {
+	// The OBIS code, eg. "1-0:1.8.0"
+	ID string
+	// The content of every bracket group, some objects (eg. the power failure event log) have several
+	Groups []string
+	// The content of the last bracket group without unit, as sent by the meter, eg. "000006.695"
+	RawValue string
+	// The numeric value of RawValue, only valid if Numeric is true
+	Value float64
+	// The unit of the value, empty if the meter did not send one
+	Unit    string
+	Numeric bool
+}
+
+// Returns the value as integer, eg. for counters
+func (o Object) Int() int64 {
+	return int64(o.Value)
+}
+
+// Struct holding a parsed telegram
+type Reading struct {
+	// The identification line without the leading '/', eg. "Lux5\253663629_D"
+	Header      string
+	Version     string
+	EquipmentID string
+	Timestamp   time.Time
+	// True if the meter reported daylight saving time (summer time)
+	DST bool
+	// False if the checksum did not match, only possible with ChecksumLenient
+	ChecksumValid bool
+	// All measurement objects in the order of the telegram, version, timestamp and equipment ID excluded
+	Objects []Object
+
+	// Meter readings in kWh
+	EnergyImport, EnergyExport float64
+	// Meter readings in kvarh
+	ReactiveEnergyImport, ReactiveEnergyExport float64
+	// Instantaneous power in kW
+	PowerImport, PowerExport                    float64
+	PowerImportL1, PowerImportL2, PowerImportL3 float64
+	PowerExportL1, PowerExportL2, PowerExportL3 float64
+	// Instantaneous reactive power in kvar
+	ReactivePowerImport, ReactivePowerExport float64
+	// Instantaneous voltage in V
+	VoltageL1, VoltageL2, VoltageL3 float64
+	// Instantaneous current in A
+	CurrentL1, CurrentL2, CurrentL3 float64
+}
+
+// Returns the object with the given OBIS code
+// Parameter:
+// * id: the OBIS code, eg. obis.EnergyImport
+// Return:
+// * object: the parsed object
+// * ok: false if the telegram does not contain the OBIS code
+func (r Reading) Object(id string) (object Object, ok bool) {
+	for _, object = range r.Objects {
+		if object.ID == id {
+			return object, true
+		}
+	}
+	return Object{}, false
+}
+
+// Parses a decrypted telegram, rejecting it if the checksum does not match
+// Parameter:
+// * plainText: the telegram, starting with the '/' identification line and ending with the '!' line
+// Return:
+// * reading: the parsed telegram
+// * err: ErrFormat if the telegram could not be parsed, a ChecksumError if the checksum does not match
+func Parse(plainText []byte) (reading Reading, err error) {
+	return ParseWithMode(plainText, ChecksumStrict)
+}
+
+// Parses a decrypted telegram
+// Parameter:
+// * plainText: the telegram, starting with the '/' identification line and ending with the '!' line
+// * mode: ChecksumStrict to reject telegrams with invalid checksum, ChecksumLenient to flag them
+// Return:
+// * reading: the parsed telegram
+// * err: ErrFormat if the telegram could not be parsed, a ChecksumError in strict mode
+func ParseWithMode(plainText []byte, mode ChecksumMode) (reading Reading, err error) {
+	checksumErr := VerifyChecksum(plainText)
+	if checksumErr != nil && (mode == ChecksumStrict || !errors.Is(checksumErr, ErrChecksum)) {
+		return Reading{}, checksumErr
+	}
+	reading.ChecksumValid = checksumErr == nil
+
+	lines := strings.Split(string(plainText), "\n")
+	headerFound := false
+	for number, line := range lines {
+		line = strings.TrimRight(line, "\r")
+		switch {
+		case line == "":
+			continue
+		case strings.HasPrefix(line, "/"):
+			reading.Header = line[1:]
+			headerFound = true
+			continue
+		case strings.HasPrefix(line, "!"):
+			if !headerFound {
+				return Reading{}, fmt.Errorf("%w: identification line missing", ErrFormat)
+			}
+			return reading, nil
+		}
+
+		object, err := parseObject(line)
+		if err != nil {
+			return Reading{}, fmt.Errorf("%w: line %d: %v", ErrFormat, number+1, err)
+		}
+		if err = reading.assign(object); err != nil {
+			return Reading{}, fmt.Errorf("%w: line %d: %v", ErrFormat, number+1, err)
+		}
+	}
+	return Reading{}, fmt.Errorf("%w: end of telegram ('!') missing", ErrFormat)
+}
+
+func parseObject(line string) (object Object, err error) {
+	start := strings.IndexByte(line, '(')
+	if start <= 0 || !strings.HasSuffix(line, ")") {
+		return Object{}, fmt.Errorf("no OBIS object: %q", line)
+	}
+	object.ID = line[:start]
+	object.Groups = strings.Split(line[start+1:len(line)-1], ")(")
+
+	object.RawValue = object.Groups[len(object.Groups)-1]
+	if separator := strings.IndexByte(object.RawValue, '*'); separator >= 0 {
+		object.Unit = object.RawValue[separator+1:]
+		object.RawValue = object.RawValue[:separator]
+	}
+	if value, err := strconv.ParseFloat(object.RawValue, 64); err == nil {
+		object.Value = value
+		object.Numeric = true
+	}
+	return object, nil
+}
+
+func (r *Reading) assign(object Object) (err error) {
+	switch object.ID {
+	case Version:
+		r.Version = object.RawValue
+		return nil
+	case Timestamp:
+		r.Timestamp, r.DST, err = ParseTimestamp(object.RawValue)
+		return err
+	case EquipmentID:
+		r.EquipmentID = decodeEquipmentID(object.RawValue)
+		return nil
+	}
+	r.Objects = append(r.Objects, object)
+
+	if field := r.field(object.ID); field != nil && object.Numeric {
+		*field = object.Value
+	}
+	return nil
+}
+
+func (r *Reading) field(id string) *float64 {
+	switch id {
+	case EnergyImport:
+		return &r.EnergyImport
+	case EnergyExport:
+		return &r.EnergyExport
+	case ReactiveEnergyImport:
+		return &r.ReactiveEnergyImport
+	case ReactiveEnergyExport:
+		return &r.ReactiveEnergyExport
+	case PowerImport:
+		return &r.PowerImport
+	case PowerExport:
+		return &r.PowerExport
+	case ReactivePowerImport:
+		return &r.ReactivePowerImport
+	case ReactivePowerExport:
+		return &r.ReactivePowerExport
+	case PowerImportL1:
+		return &r.PowerImportL1
+	case PowerImportL2:
+		return &r.PowerImportL2
+	case PowerImportL3:
+		return &r.PowerImportL3
+	case PowerExportL1:
+		return &r.PowerExportL1
+	case PowerExportL2:
+		return &r.PowerExportL2
+	case PowerExportL3:
+		return &r.PowerExportL3
+	case VoltageL1:
+		return &r.VoltageL1
+	case VoltageL2:
+		return &r.VoltageL2
+	case VoltageL3:
+		return &r.VoltageL3
+	case CurrentL1:
+		return &r.CurrentL1
+	case CurrentL2:
+		return &r.CurrentL2
+	case CurrentL3:
+		return &r.CurrentL3
+	}
+	return nil
+}
+
+// Parses a P1 timestamp
+// The meter sends its local time (Luxembourg), followed by 'W' for winter time (CET) or 'S' for summer time (CEST).
+// Parameter:
+// * value: the timestamp in the format YYMMDDhhmmssX, eg. "180130102122W"
+// Return:
+// * timestamp: the parsed time including the time zone offset
+// * dst: true for summer time
+// * err: error if the timestamp could not be parsed
+func ParseTimestamp(value string) (timestamp time.Time, dst bool, err error) {
+	if len(value) != 13 {
+		return time.Time{}, false, fmt.Errorf("invalid timestamp: %q", value)
+	}
+	var zone *time.Location
+	switch value[12] {
+	case 'W':
+		zone = time.FixedZone("CET", 1*60*60)
+	case 'S':
+		zone = time.FixedZone("CEST", 2*60*60)
+		dst = true
+	default:
+		return time.Time{}, false, fmt.Errorf("invalid timestamp daylight saving flag: %q", value)
+	}
+	timestamp, err = time.ParseInLocation("060102150405", value[:12], zone)
+	if err != nil {
+		return time.Time{}, false, fmt.Errorf("invalid timestamp: %q", value)
+	}
+	return timestamp, dst, nil
+}
+
+// The equipment ID is sent as hex encoded ASCII string, keep the raw value if it is not
+func decodeEquipmentID(value string) string {
+	decoded, err := hex.DecodeString(value)
+	if err != nil {
+		return value
+	}
+	for _, character := range decoded {
+		if character < 0x20 || character > 0x7E {
+			return value
+		}
+	}
+	return string(decoded)
+}
@@ -0,0 +1,143 @@
+/*
+ * This file is part of go-smarty-reader
+ *
+ * go-smarty-reader is free software: you can redistribute it and/or modify
+ * it under the terms of the GNU General Public License as published by
+ * the Free Software Foundation, either version 3 of the License, or
+ * (at your option) any later version.
+ *
+ * go-smarty-reader is distributed in the hope that it will be useful,
+ * but WITHOUT ANY WARRANTY; without even the implied warranty of
+ * MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+ * GNU General Public License for more details.
+ *
+ * You should have received a copy of the GNU General Public License
+ * along with go-smarty-reader. If not, see <https://www.gnu.org/licenses/>.
+ */
+
+package obis_test
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/NEXXTLAB/go-smarty-reader/smarty/obis"
+)
+
+// Decrypted telegram of the testing device at NEXXTLAB (see smarty/Smarty_test.go), extended by phase values
+var telegram = withChecksum(strings.Join([]string{
+	"/Lux5\\253663629_D",
+	"",
+	"1-3:0.2.8(42)",
+	"0-0:1.0.0(180130102122W)",
+	"0-0:42.0.0(53414731303330373030313134303034)",
+	"1-0:1.8.0(000006.695*kWh)",
+	"1-0:2.8.0(000000.025*kWh)",
+	"1-0:3.8.0(000000.818*kvarh)",
+	"1-0:4.8.0(000002.745*kvarh)",
+	"1-0:1.7.0(00.312*kW)",
+	"1-0:2.7.0(00.000*kW)",
+	"1-0:3.7.0(00.000)",
+	"1-0:4.7.0(00.007)",
+	"0-0:17.0.0(77.376)",
+	"0-0:96.3.10(1)",
+	"0-0:96.7.21(00069)",
+	"0-0:96.13.0()",
+	"1-0:21.7.0(00.104*kW)",
+	"1-0:41.7.0(00.108*kW)",
+	"1-0:61.7.0(00.100*kW)",
+	"1-0:32.7.0(231.0*V)",
+	"1-0:52.7.0(229.5*V)",
+	"1-0:72.7.0(230.2*V)",
+	"1-0:31.7.0(001*A)",
+	"1-0:51.7.0(000*A)",
+	"1-0:71.7.0(000*A)",
+	"!",
+}, "\r\n"))
+
+// Appends the checksum to a telegram ending with '!'
+func withChecksum(body string) string {
+	return body + fmt.Sprintf("%04X\r\n", obis.Checksum([]byte(body)))
+}
+
+// Test if the header, metadata and the named measurements are parsed
+func TestParse(t *testing.T) {
+	reading, err := obis.Parse([]byte(telegram))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reading.Header != "Lux5\\253663629_D" || reading.Version != "42" {
+		t.Errorf("Unexpected header %q or version %q", reading.Header, reading.Version)
+	}
+	if reading.EquipmentID != "SAG1030700114004" {
+		t.Errorf("Unexpected equipment ID %q", reading.EquipmentID)
+	}
+	expectedTime := time.Date(2018, time.January, 30, 9, 21, 22, 0, time.UTC)
+	if !reading.Timestamp.Equal(expectedTime) || reading.DST {
+		t.Errorf("Unexpected timestamp %s (DST %t)", reading.Timestamp, reading.DST)
+	}
+
+	values := map[string]float64{
+		"EnergyImport":  reading.EnergyImport,
+		"EnergyExport":  reading.EnergyExport,
+		"PowerImport":   reading.PowerImport,
+		"PowerImportL2": reading.PowerImportL2,
+		"VoltageL3":     reading.VoltageL3,
+		"CurrentL1":     reading.CurrentL1,
+	}
+	expected := map[string]float64{
+		"EnergyImport":  6.695,
+		"EnergyExport":  0.025,
+		"PowerImport":   0.312,
+		"PowerImportL2": 0.108,
+		"VoltageL3":     230.2,
+		"CurrentL1":     1,
+	}
+	for name, value := range expected {
+		if values[name] != value {
+			t.Errorf("%s: expected %v, got %v", name, value, values[name])
+		}
+	}
+
+	object, ok := reading.Object(obis.EnergyImport)
+	if !ok || object.RawValue != "000006.695" || object.Unit != "kWh" {
+		t.Errorf("Unexpected object %+v", object)
+	}
+	if object, ok = reading.Object(obis.PowerFailures); !ok || object.Int() != 69 || object.Unit != "" {
+		t.Errorf("Unexpected object %+v", object)
+	}
+	if _, ok = reading.Object(obis.Timestamp); ok {
+		t.Error("The timestamp should not be part of the objects")
+	}
+}
+
+// Test if summer time is recognised
+func TestParseTimestamp(t *testing.T) {
+	timestamp, dst, err := obis.ParseTimestamp("190701120000S")
+	if err != nil || !dst || !timestamp.Equal(time.Date(2019, time.July, 1, 10, 0, 0, 0, time.UTC)) {
+		t.Errorf("Unexpected timestamp %s (DST %t, error %v)", timestamp, dst, err)
+	}
+	if _, _, err = obis.ParseTimestamp("190701120000X"); err == nil {
+		t.Error("Invalid daylight saving flag accepted")
+	}
+}
+
+// Test if malformed telegrams are rejected
+func TestParseMalformed(t *testing.T) {
+	body := telegram[:strings.LastIndex(telegram, "!")+1]
+	for _, input := range []string{
+		"",
+		telegram[:strings.LastIndex(telegram, "!")],
+		withChecksum(strings.Replace(body, "/Lux5", "Lux5", 1)),
+		withChecksum(strings.Replace(body, "0-0:1.0.0(180130102122W)", "0-0:1.0.0(1801301021)", 1)),
+		withChecksum(strings.Replace(body, "1-0:1.8.0(000006.695*kWh)", "1-0:1.8.0", 1)),
+	} {
+		if _, err := obis.Parse([]byte(input)); !errors.Is(err, obis.ErrFormat) {
+			t.Errorf("Expected ErrFormat, got %v", err)
+		}
+	}
+}